@@ -0,0 +1,88 @@
+package pipe
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// SetAuditWriter sets a writer receiving an audit record of every function run during the
+// executions of the pipe, including failing ones. A nil writer disables auditing.
+//
+// Each record is a JSON object on its own line, with the index and name of the function, its
+// inputs and outputs, and its error if any:
+//
+//	{"stage":0,"name":"strings.ToUpper","inputs":["a"],"outputs":["A"]}
+//
+// Errors are encoded as their message, and other values as JSON when possible, e.g. not for
+// functions or channels, otherwise as their default format (see fmt). Records of concurrent
+// executions are written one at a time, and errors writing them are reported through the logger.
+func (p *Pipe) SetAuditWriter(w io.Writer) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.audit = nil
+	if w != nil {
+		p.audit = &auditWriter{w: w}
+	}
+}
+
+// auditWriter writes audit records, see SetAuditWriter.
+type auditWriter struct {
+	mux sync.Mutex
+	w   io.Writer
+}
+
+// auditRecord is the audit record of a function run.
+type auditRecord struct {
+	Stage   int           `json:"stage"`
+	Name    string        `json:"name"`
+	Inputs  []interface{} `json:"inputs"`
+	Outputs []interface{} `json:"outputs,omitempty"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// write writes the audit record of the function fn, run at the given index.
+func (a *auditWriter) write(index int, fn interface{}, inputs, outputs []interface{}, err error) error {
+	rec := auditRecord{
+		Stage:   index,
+		Name:    funcName(fn),
+		Inputs:  auditValues(inputs),
+		Outputs: auditValues(outputs),
+	}
+	if err != nil {
+		var stageErr *StageError
+		if errors.As(err, &stageErr) {
+			err = stageErr.Err
+		}
+		rec.Error = err.Error()
+	}
+	b, jsonErr := json.Marshal(rec)
+	if jsonErr != nil {
+		return jsonErr
+	}
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	_, err = a.w.Write(append(b, '\n'))
+	return err
+}
+
+// auditValues returns the values as encoded in audit records.
+func auditValues(values []interface{}) []interface{} {
+	if values == nil {
+		return nil
+	}
+	encoded := make([]interface{}, len(values))
+	for i, v := range values {
+		if err, ok := v.(error); ok {
+			encoded[i] = err.Error()
+		} else if b, err := json.Marshal(v); err == nil {
+			encoded[i] = json.RawMessage(b)
+		} else {
+			encoded[i] = fmt.Sprintf("%v", v)
+		}
+	}
+	return encoded
+}
@@ -0,0 +1,46 @@
+package pipe
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPipe_SetAuditWriter(t *testing.T) {
+	p, err := New(strings.TrimSpace, strconv.Atoi, func(n int) []int { return []int{n, n * 2} })
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	var audit bytes.Buffer
+	p.SetAuditWriter(&audit)
+
+	if _, err := p.Execute(" 21 "); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := p.Execute("x"); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(audit.String()), "\n") {
+		var rec map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("invalid audit record %q: %v", line, err)
+		}
+		records = append(records, rec)
+	}
+	atoiErr := `strconv.Atoi: parsing "x": invalid syntax`
+	expected := []map[string]interface{}{
+		{"stage": 0.0, "name": "strings.TrimSpace", "inputs": []interface{}{" 21 "}, "outputs": []interface{}{"21"}},
+		{"stage": 1.0, "name": "strconv.Atoi", "inputs": []interface{}{"21"}, "outputs": []interface{}{21.0}},
+		{"stage": 2.0, "name": records[2]["name"], "inputs": []interface{}{21.0}, "outputs": []interface{}{[]interface{}{21.0, 42.0}}},
+		{"stage": 0.0, "name": "strings.TrimSpace", "inputs": []interface{}{"x"}, "outputs": []interface{}{"x"}},
+		{"stage": 1.0, "name": "strconv.Atoi", "inputs": []interface{}{"x"}, "outputs": []interface{}{0.0, atoiErr}, "error": atoiErr},
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("audit mismatch:\nexpected %v\ngot      %v", expected, records)
+	}
+}
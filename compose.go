@@ -0,0 +1,188 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Concat returns a new pipe executing the functions of all the given pipes, in order.
+// The new pipe has the default settings.
+func Concat(pipes ...*Pipe) *Pipe {
+	c, _ := concat(pipes)
+	return c
+}
+
+// concat implements Concat, also returning the indexes in the new pipe of the last function
+// of every non-empty pipe.
+func concat(pipes []*Pipe) (*Pipe, []int) {
+	c := &Pipe{}
+	var seams []int
+	for _, p := range pipes {
+		p.mux.Lock()
+		if len(p.funcs) > 0 {
+			c.funcs = append(c.funcs, p.funcs...)
+			seams = append(seams, len(c.funcs)-1)
+		}
+		p.mux.Unlock()
+	}
+	return c, seams
+}
+
+// Combine behaves like Concat, but first checks, like Validate, that the outputs of the last
+// function of each pipe can be passed to the first function of the next non-empty one.
+func Combine(pipes ...*Pipe) (*Pipe, error) {
+	c, seams := concat(pipes)
+	for k := 0; k+1 < len(seams); k++ {
+		i := seams[k]
+		if err := c.validateBoundary(i, c.funcs[i], c.funcs[i+1]); err != nil {
+			return nil, fmt.Errorf("incompatible pipes: %w", err)
+		}
+	}
+	return c, nil
+}
+
+// Invert returns a new pipe undoing what p does, e.g. a decoding pipe for an encoding one: it
+// executes inverses[i], the inverse of function i of p, for every function of p in reverse
+// order. The new pipe has the default settings, and is validated (see Validate).
+//
+// Every function of p must have an inverse, and inverses must not have other keys.
+func (p *Pipe) Invert(inverses map[int]interface{}) (*Pipe, error) {
+	p.mux.Lock()
+	n := len(p.funcs)
+	p.mux.Unlock()
+
+	for i := range inverses {
+		if i < 0 || i >= n {
+			return nil, fmt.Errorf("inverse of function %d, but the pipe has %d functions", i, n)
+		}
+	}
+	funcs := make([]interface{}, n)
+	for i := 0; i < n; i++ {
+		f, ok := inverses[i]
+		if !ok {
+			return nil, fmt.Errorf("function %d has no inverse", i)
+		}
+		if err := checkFunc(f); err != nil {
+			return nil, fmt.Errorf("inverse of function %d: %w", i, err)
+		}
+		funcs[n-1-i] = f
+	}
+
+	inv, err := New(funcs...)
+	if err != nil {
+		return nil, err
+	}
+	if err := inv.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid inverse: %w", err)
+	}
+	return inv, nil
+}
+
+// FanIn returns a pipe that executes every given pipe concurrently with the same arguments and
+// passes their outputs, in the order of pipes, to combine, whose result is the pipe's output.
+//
+// If any pipe fails, the error of the first failing pipe (in the order of pipes) is returned
+// and combine isn't called. Panics are always recovered, as if SetRecoverPanics was enabled on
+// every pipe, and returned as the error of the panicking pipe.
+func FanIn(combine func([][]interface{}) []interface{}, pipes ...*Pipe) (*Pipe, error) {
+	if combine == nil {
+		return nil, errors.New("combine function is nil")
+	}
+	for i, sub := range pipes {
+		if sub == nil {
+			return nil, fmt.Errorf("pipe %d is nil", i)
+		}
+	}
+	p := &Pipe{}
+	p.insert(stage{fn: &fanInStage{combine: combine, pipes: pipes}})
+	return p, nil
+}
+
+// fanInStage is the Stage of the pipe returned by FanIn.
+type fanInStage struct {
+	combine func([][]interface{}) []interface{}
+	pipes   []*Pipe
+}
+
+func (s *fanInStage) Run(inputs []interface{}) ([]interface{}, error) {
+	return (&Pipe{}).runFanIn(&run{ctx: context.Background()}, s, inputs)
+}
+
+// runFanIn runs the pipes of s with the inputs, on behalf of p. The goroutines running them are
+// registered as executing p, so that a function of a pipe executing p is detected as reentrant
+// (see DetectReentrancy).
+func (p *Pipe) runFanIn(r *run, s *fanInStage, inputs []interface{}) ([]interface{}, error) {
+	results := make([]Result, len(s.pipes))
+	var wg sync.WaitGroup
+	for i, sub := range s.pipes {
+		wg.Add(1)
+		go func(i int, sub *Pipe) {
+			defer wg.Done()
+			if r.detectReentrancy {
+				id := goroutineID()
+				p.running.add(id)
+				defer p.running.remove(id)
+			}
+			if err := sub.lock(); err != nil {
+				results[i] = Result{Err: err}
+				return
+			}
+			defer sub.mux.Unlock()
+			out, err := sub.execute(context.Background(), sub.withDefaults(inputs), execOptions{recoverPanics: true})
+			results[i] = Result{Outputs: out, Err: err}
+		}(i, sub)
+	}
+	wg.Wait()
+
+	outputs := make([][]interface{}, len(results))
+	for i, r := range results {
+		if r.Err != nil {
+			return nil, r.Err
+		}
+		outputs[i] = r.Outputs
+	}
+	return s.combine(outputs), nil
+}
+
+// AddSwitch inserts a stage to the end of the execution stack that routes its inputs to one of
+// several pipes: selector returns the key of the case to execute with the inputs, whose
+// outputs are forwarded to the next function. If no case matches the key, the case with the
+// empty key is executed if there is one, otherwise Execute fails.
+//
+// cases is copied, so changing it afterwards has no effect, but the pipes are not.
+func (p *Pipe) AddSwitch(selector func(inputs []interface{}) string, cases map[string]*Pipe) error {
+	if selector == nil {
+		return errors.New("selector is nil")
+	}
+	s := &switchStage{selector: selector, cases: make(map[string]*Pipe, len(cases))}
+	for key, c := range cases {
+		if c == nil {
+			return fmt.Errorf("case %q is nil", key)
+		}
+		s.cases[key] = c
+	}
+	return p.AddStage(s)
+}
+
+// switchStage is the Stage inserted by AddSwitch.
+type switchStage struct {
+	selector func(inputs []interface{}) string
+	cases    map[string]*Pipe
+}
+
+func (s *switchStage) Run(inputs []interface{}) ([]interface{}, error) {
+	key := s.selector(inputs)
+	c, ok := s.cases[key]
+	if !ok {
+		if c, ok = s.cases[""]; !ok {
+			return nil, fmt.Errorf("no case for key %q", key)
+		}
+	}
+	out, err := c.Execute(inputs...)
+	if err != nil {
+		return nil, fmt.Errorf("case %q: %w", key, err)
+	}
+	return out, nil
+}
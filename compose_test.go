@@ -0,0 +1,223 @@
+package pipe
+
+import (
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFanIn(t *testing.T) {
+	upper, err := New(strings.ToUpper)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	length, err := New(func(s string) int { return len(s) })
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	p, err := FanIn(func(outputs [][]interface{}) []interface{} {
+		var combined []interface{}
+		for _, out := range outputs {
+			combined = append(combined, out...)
+		}
+		return combined
+	}, upper, length)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	out, err := p.Execute("hello")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{"HELLO", 5}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{"HELLO", 5}, out)
+	}
+
+	if _, err := p.Execute(1); err == nil {
+		t.Error("expected an error when a sub-pipe fails")
+	}
+
+	panicking, err := New(func(s string) string { panic("boom") })
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	p, err = FanIn(func(outputs [][]interface{}) []interface{} { return nil }, upper, panicking)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	_, err = p.Execute("hello")
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) {
+		t.Errorf("expected a *PanicError when a sub-pipe panics, got %v", err)
+	}
+
+	if _, err := FanIn(nil, upper); err == nil {
+		t.Error("expected an error for a nil combine function")
+	}
+	if _, err := FanIn(func(outputs [][]interface{}) []interface{} { return nil }, upper, nil); err == nil {
+		t.Error("expected an error for a nil pipe")
+	}
+}
+
+func TestFanIn_detectReentrancy(t *testing.T) {
+	var p *Pipe
+	var inner error
+	sub, err := New(func(a int) int {
+		if a > 0 {
+			_, inner = p.Execute(a - 1)
+		}
+		return a
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	p, err = FanIn(func(outputs [][]interface{}) []interface{} { return outputs[0] }, sub)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	p.DetectReentrancy(true)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.Execute(1)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("reentrant Execute through a FanIn pipe deadlocked")
+	}
+	if !errors.Is(inner, ErrReentrant) {
+		t.Errorf("expected %v, got %v", ErrReentrant, inner)
+	}
+}
+
+func TestCombine(t *testing.T) {
+	parse, err := New(strings.TrimSpace, strconv.Atoi)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	format, err := New(func(n int) int { return n * 2 }, strconv.Itoa)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	p, err := Combine(parse, &Pipe{}, format)
+	if err != nil {
+		t.Fatalf("unexpected error combining compatible pipes: %v", err)
+	}
+	out, err := p.Execute(" 21 ")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{"42"}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{"42"}, out)
+	}
+
+	if _, err := Combine(format, format); err == nil {
+		t.Error("expected an error combining incompatible pipes")
+	}
+}
+
+func TestPipe_Invert(t *testing.T) {
+	encode, err := New(
+		func(s string) []byte { return []byte(s) },
+		hex.EncodeToString,
+	)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	inverses := map[int]interface{}{
+		0: func(b []byte) string { return string(b) },
+	}
+	if _, err := encode.Invert(inverses); err == nil || err.Error() != "function 1 has no inverse" {
+		t.Errorf("expected an error for a missing inverse, got %v", err)
+	}
+
+	inverses[1] = hex.DecodeString
+	decode, err := encode.Invert(inverses)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	encoded, err := encode.Execute("round trip")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	decoded, err := decode.Execute(encoded...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, []interface{}{"round trip"}) {
+		t.Errorf("round trip mismatch: expected %v, got %v", []interface{}{"round trip"}, decoded)
+	}
+
+	inverses[2] = strings.ToLower
+	if _, err := encode.Invert(inverses); err == nil {
+		t.Error("expected an error for an inverse of a missing function")
+	}
+	delete(inverses, 2)
+	inverses[0] = strconv.Itoa
+	if _, err := encode.Invert(inverses); err == nil {
+		t.Error("expected an error for inverses that don't fit together")
+	}
+}
+
+func TestPipe_AddSwitch(t *testing.T) {
+	even, err := New(func(n int) string { return strconv.Itoa(n) + " is even" })
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	odd, err := New(func(n int) string { return strconv.Itoa(n) + " is odd" })
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	p, err := New(func(n int) int { return n + 1 })
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	parity := func(inputs []interface{}) string {
+		if inputs[0].(int)%2 == 0 {
+			return "even"
+		}
+		return "odd"
+	}
+	if err := p.AddSwitch(parity, map[string]*Pipe{"even": even, "odd": odd}); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+	if err := p.Add(strings.ToUpper); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+
+	for n, expected := range map[int]string{1: "2 IS EVEN", 2: "3 IS ODD"} {
+		out, err := p.Execute(n)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(out, []interface{}{expected}) {
+			t.Errorf("output mismatch: expected %v, got %v", []interface{}{expected}, out)
+		}
+	}
+
+	p, _ = New()
+	if err := p.AddSwitch(parity, map[string]*Pipe{"even": even}); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+	if _, err := p.Execute(1); err == nil {
+		t.Error("expected an error for an unmatched key")
+	}
+
+	p, _ = New()
+	if err := p.AddSwitch(parity, map[string]*Pipe{"even": even, "": odd}); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+	if out, err := p.Execute(1); err != nil || !reflect.DeepEqual(out, []interface{}{"1 is odd"}) {
+		t.Errorf("expected the default case to run, got %v, %v", out, err)
+	}
+}
@@ -0,0 +1,98 @@
+package pipe
+
+import "reflect"
+
+// CopyInputs enables or disables deep-copying the arguments of every execution before passing
+// them to the first function, so that functions mutating them, e.g. appending to a slice
+// through a pointer or filling a map, don't change the caller's values.
+//
+// Pointers, slices, maps, arrays, interfaces and the exported fields of structs are copied
+// recursively, keeping pointers to the same value, including cyclic ones, shared in the copy.
+// A pointer into another value, e.g. to a field of a struct, points to a separate copy though.
+// Unexported fields, functions and channels are shared with the original. Copying walks and allocates the whole of every
+// argument on each execution, so it is only worth its cost for arguments that are both small
+// and likely to be mutated.
+func (p *Pipe) CopyInputs(copy bool) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.copyInputs = copy
+}
+
+// deepCopyValues returns deep copies of values, see CopyInputs.
+func deepCopyValues(values []interface{}) []interface{} {
+	copies := make([]interface{}, len(values))
+	seen := map[copiedPointer]reflect.Value{}
+	for i, v := range values {
+		if v != nil {
+			copies[i] = deepCopy(reflect.ValueOf(v), seen).Interface()
+		}
+	}
+	return copies
+}
+
+// copiedPointer identifies a pointer copied by deepCopy. The type is needed as well as the
+// address, since a struct and its first field, for instance, have the same address.
+type copiedPointer struct {
+	addr uintptr
+	typ  reflect.Type
+}
+
+// deepCopy returns a deep copy of v. seen holds the copies of the pointers already copied.
+func deepCopy(v reflect.Value, seen map[copiedPointer]reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return v
+		}
+		key := copiedPointer{addr: v.Pointer(), typ: v.Type()}
+		if c, ok := seen[key]; ok {
+			return c
+		}
+		c := reflect.New(v.Type().Elem())
+		seen[key] = c
+		c.Elem().Set(deepCopy(v.Elem(), seen))
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(deepCopy(v.Index(i), seen))
+		}
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for it := v.MapRange(); it.Next(); {
+			c.SetMapIndex(deepCopy(it.Key(), seen), deepCopy(it.Value(), seen))
+		}
+		return c
+	case reflect.Array:
+		c := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(deepCopy(v.Index(i), seen))
+		}
+		return c
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(deepCopy(v.Elem(), seen))
+		return c
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				c.Field(i).Set(deepCopy(v.Field(i), seen))
+			}
+		}
+		return c
+	default:
+		return v
+	}
+}
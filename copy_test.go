@@ -0,0 +1,96 @@
+package pipe
+
+import (
+	"reflect"
+	"testing"
+)
+
+type copyNode struct {
+	Values []int
+	Next   *copyNode
+	hidden *int
+}
+
+func TestPipe_CopyInputs(t *testing.T) {
+	mutate := func(s []int, m map[string]int, n *copyNode) int {
+		s[0] = 100
+		m["added"] = 1
+		n.Values[0] = 100
+		n.Next.Values = append(n.Next.Values, 100)
+		*n.hidden = 100
+		return len(s)
+	}
+	p, err := New(mutate)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	p.CopyInputs(true)
+
+	s := []int{1, 2}
+	m := map[string]int{"a": 1}
+	hidden := 1
+	n := &copyNode{Values: []int{1}, hidden: &hidden}
+	n.Next = n
+	out, err := p.Execute(s, m, n)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{2}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{2}, out)
+	}
+	if !reflect.DeepEqual(s, []int{1, 2}) || !reflect.DeepEqual(m, map[string]int{"a": 1}) {
+		t.Errorf("expected the caller's slice and map to be unchanged, got %v and %v", s, m)
+	}
+	if !reflect.DeepEqual(n.Values, []int{1}) || n.Next != n {
+		t.Errorf("expected the caller's node to be unchanged, got %+v", n)
+	}
+	if hidden != 100 {
+		t.Errorf("expected unexported fields to be shared, got %d", hidden)
+	}
+
+	p.CopyInputs(false)
+	if _, err := p.Execute(s, m, n); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s[0] != 100 || m["added"] != 1 {
+		t.Errorf("expected the caller's values to be mutated without copies, got %v and %v", s, m)
+	}
+}
+
+type copyPair struct {
+	A int
+	B int
+}
+
+func TestPipe_CopyInputs_interiorPointer(t *testing.T) {
+	p, err := New(func(s *copyPair, a *int) int {
+		*a = 10
+		s.B = 20
+		return s.A + s.B
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	p.CopyInputs(true)
+
+	// s and &s.A have the same address, but not the same type: they are copied separately.
+	s := &copyPair{A: 1, B: 2}
+	out, err := p.Execute(s, &s.A)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{21}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{21}, out)
+	}
+	if *s != (copyPair{A: 1, B: 2}) {
+		t.Errorf("expected the caller's value to be unchanged, got %+v", *s)
+	}
+
+	rec, _, err := p.RecordExecution(s, &s.A)
+	if err != nil {
+		t.Fatalf("unexpected error recording: %v", err)
+	}
+	if _, err := rec.Replay(p); err != nil {
+		t.Errorf("unexpected error replaying: %v", err)
+	}
+}
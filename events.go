@@ -0,0 +1,132 @@
+package pipe
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventType is the type of an Event.
+type EventType int
+
+const (
+	// ExecuteStart is published when an execution starts.
+	ExecuteStart EventType = iota
+	// StageStart is published when a function starts running.
+	StageStart
+	// StageEnd is published when a function returns, even if it fails.
+	StageEnd
+	// Error is published when an execution fails, right before ExecuteEnd.
+	Error
+	// ExecuteEnd is published when an execution ends, even if it fails.
+	ExecuteEnd
+)
+
+func (t EventType) String() string {
+	switch t {
+	case ExecuteStart:
+		return "ExecuteStart"
+	case StageStart:
+		return "StageStart"
+	case StageEnd:
+		return "StageEnd"
+	case Error:
+		return "Error"
+	case ExecuteEnd:
+		return "ExecuteEnd"
+	}
+	return "EventType(" + strconv.Itoa(int(t)) + ")"
+}
+
+// Event is a point of the lifecycle of an execution, see Subscribe.
+type Event struct {
+	Type EventType
+	// Index is the position of the function in the pipe for StageStart and StageEnd, and of the
+	// failing function for Error, or -1 if the execution failed outside of functions or the
+	// event isn't about a function.
+	Index int
+	// Time is the time of the event.
+	Time time.Time
+	// Err is the error of StageEnd, Error and ExecuteEnd, if any.
+	Err error
+}
+
+// eventBufferSize is the capacity of the channels returned by Subscribe.
+const eventBufferSize = 64
+
+// Subscribe returns a channel receiving the events of the executions of the pipe, until
+// Unsubscribe is called with it. Events are never waited for: an event is dropped for a
+// subscriber whose channel, buffered, is full. Executions ending with ErrStop aren't failures
+// and publish no Error event.
+func (p *Pipe) Subscribe() <-chan Event {
+	return p.events.subscribe()
+}
+
+// Unsubscribe stops publishing events to ch, returned by Subscribe, and closes it.
+func (p *Pipe) Unsubscribe(ch <-chan Event) {
+	p.events.unsubscribe(ch)
+}
+
+// eventBus publishes events to the subscribers of a pipe. Its zero value has no subscriber.
+type eventBus struct {
+	mux  sync.Mutex
+	subs []chan Event
+	n    atomic.Int32 // number of subscribers, checked without locking
+}
+
+func (b *eventBus) subscribe() <-chan Event {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	ch := make(chan Event, eventBufferSize)
+	b.subs = append(b.subs, ch)
+	b.n.Add(1)
+	return ch
+}
+
+func (b *eventBus) unsubscribe(ch <-chan Event) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	for i, sub := range b.subs {
+		if sub == ch {
+			b.subs = append(b.subs[:i:i], b.subs[i+1:]...)
+			b.n.Add(-1)
+			close(sub)
+			return
+		}
+	}
+}
+
+// publish sends an event of type t to every subscriber whose channel isn't full.
+func (b *eventBus) publish(t EventType, index int, err error) {
+	if b.n.Load() == 0 {
+		return
+	}
+	e := Event{Type: t, Index: index, Time: time.Now(), Err: err}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	for _, sub := range b.subs {
+		select {
+		case sub <- e:
+		default:
+		}
+	}
+}
+
+// publishEnd publishes the events of the end of an execution failing with err, if not nil.
+func (b *eventBus) publishEnd(err error) {
+	if err != nil && !errors.Is(err, ErrStop) {
+		index := -1
+		var stageErr *StageError
+		var tme *TypeMismatchError
+		switch {
+		case errors.As(err, &stageErr):
+			index = stageErr.Index
+		case errors.As(err, &tme):
+			index = tme.Index
+		}
+		b.publish(Error, index, err)
+	}
+	b.publish(ExecuteEnd, -1, err)
+}
@@ -0,0 +1,80 @@
+package pipe
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPipe_Subscribe(t *testing.T) {
+	p, err := New(strings.TrimSpace, strconv.Atoi)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	events := p.Subscribe()
+
+	type event struct {
+		Type  EventType
+		Index int
+		Err   bool
+	}
+	receive := func() []event {
+		var received []event
+		for len(events) > 0 {
+			e := <-events
+			if e.Time.IsZero() {
+				t.Errorf("expected %v to have a time", e.Type)
+			}
+			received = append(received, event{e.Type, e.Index, e.Err != nil})
+		}
+		return received
+	}
+
+	if _, err := p.Execute(" 42 "); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []event{
+		{ExecuteStart, -1, false},
+		{StageStart, 0, false},
+		{StageEnd, 0, false},
+		{StageStart, 1, false},
+		{StageEnd, 1, false},
+		{ExecuteEnd, -1, false},
+	}
+	if received := receive(); !reflect.DeepEqual(received, expected) {
+		t.Errorf("events mismatch:\nexpected %v\ngot      %v", expected, received)
+	}
+
+	if _, err := p.Execute("x"); err == nil {
+		t.Fatal("expected an error")
+	}
+	expected = []event{
+		{ExecuteStart, -1, false},
+		{StageStart, 0, false},
+		{StageEnd, 0, false},
+		{StageStart, 1, false},
+		{StageEnd, 1, true},
+		{Error, 1, true},
+		{ExecuteEnd, -1, true},
+	}
+	if received := receive(); !reflect.DeepEqual(received, expected) {
+		t.Errorf("events mismatch:\nexpected %v\ngot      %v", expected, received)
+	}
+
+	// Events are dropped rather than blocking executions when the channel is full.
+	for i := 0; i < eventBufferSize; i++ {
+		p.Execute("1")
+	}
+	if len(events) != eventBufferSize {
+		t.Errorf("expected a full channel, got %d events", len(events))
+	}
+
+	// Unsubscribing closes the channel, so that draining it ends.
+	p.Unsubscribe(events)
+	for range events {
+	}
+	if _, err := p.Execute("1"); err != nil {
+		t.Errorf("unexpected error without subscribers: %v", err)
+	}
+}
@@ -1,3 +1,3 @@
 module github.com/ntden/go-pipe
 
-go 1.16
+go 1.23
@@ -0,0 +1,67 @@
+package pipe
+
+import (
+	"errors"
+	"net/http"
+)
+
+// HTTPMiddleware returns an HTTP middleware running the pipe before the next handler.
+//
+// The functions of the pipe are expected to have the following signature, the writer and
+// request returned by one being passed to the next one, and the last ones to the next handler:
+//
+//	func(http.ResponseWriter, *http.Request) (http.ResponseWriter, *http.Request, error)
+//
+// If a function returns an error, the request is aborted as with ExecuteHTTP and the next
+// handler isn't called. A function that wrote the response itself can return ErrStop to skip
+// the next handler without writing anything else.
+func (p *Pipe) HTTPMiddleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			out, err := p.Execute(w, r)
+			if errors.Is(err, ErrStop) {
+				return
+			}
+			if err != nil {
+				writeHTTPError(w, err)
+				return
+			}
+
+			if len(out) >= 2 {
+				outW, okW := out[0].(http.ResponseWriter)
+				outR, okR := out[1].(*http.Request)
+				if okW && okR {
+					next.ServeHTTP(outW, outR)
+					return
+				}
+			}
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		})
+	}
+}
+
+// HTTPError is an error carrying the HTTP status code to respond with, see ExecuteHTTP.
+type HTTPError interface {
+	error
+	StatusCode() int
+}
+
+// ExecuteHTTP executes the pipe with args and, if it fails, responds to the request with an
+// error: if the error wraps an HTTPError, its status code and message are used, otherwise a
+// 500 status code with a generic message, so that internal errors aren't disclosed. On success,
+// or on ErrStop, nothing is written, as the functions are expected to write the response.
+func (p *Pipe) ExecuteHTTP(w http.ResponseWriter, args ...interface{}) {
+	if _, err := p.Execute(args...); err != nil && !errors.Is(err, ErrStop) {
+		writeHTTPError(w, err)
+	}
+}
+
+// writeHTTPError responds with err, see ExecuteHTTP.
+func writeHTTPError(w http.ResponseWriter, err error) {
+	var httpErr HTTPError
+	if errors.As(err, &httpErr) {
+		http.Error(w, httpErr.Error(), httpErr.StatusCode())
+		return
+	}
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
@@ -0,0 +1,90 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type ctxKey string
+
+func TestPipe_HTTPMiddleware(t *testing.T) {
+	p, err := New(
+		func(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request, error) {
+			if r.Header.Get("Authorization") == "" {
+				return w, r, errors.New("unauthorized")
+			}
+			return w, r, nil
+		},
+		func(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request, error) {
+			w.Header().Set("X-Pipe", "yes")
+			return w, r.WithContext(context.WithValue(r.Context(), ctxKey("user"), "alice")), nil
+		},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	handler := p.HTTPMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello "+r.Context().Value(ctxKey("user")).(string))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "token")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.String() != "hello alice" || rec.Header().Get("X-Pipe") != "yes" {
+		t.Errorf("unexpected response: %d %q %v", rec.Code, rec.Body.String(), rec.Header())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected status %d, got %d", http.StatusInternalServerError, rec.Code)
+	}
+}
+
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string   { return e.msg }
+func (e *statusError) StatusCode() int { return e.code }
+
+func TestPipe_ExecuteHTTP(t *testing.T) {
+	p, err := New(func(w http.ResponseWriter, id string) error {
+		switch id {
+		case "missing":
+			return &statusError{code: http.StatusNotFound, msg: "no such item"}
+		case "broken":
+			return errors.New("database password rejected")
+		}
+		io.WriteString(w, "item "+id)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	p.ExecuteHTTP(rec, rec, "missing")
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "no such item") {
+		t.Errorf("unexpected response: %d %q", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	p.ExecuteHTTP(rec, rec, "broken")
+	if rec.Code != http.StatusInternalServerError || strings.Contains(rec.Body.String(), "password") {
+		t.Errorf("unexpected response: %d %q", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	p.ExecuteHTTP(rec, rec, "42")
+	if rec.Code != http.StatusOK || rec.Body.String() != "item 42" {
+		t.Errorf("unexpected response: %d %q", rec.Code, rec.Body.String())
+	}
+}
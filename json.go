@@ -0,0 +1,111 @@
+package pipe
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// ExecuteJSON decodes the arguments of the first function from argsJSON, executes the pipe and
+// returns its outputs encoded as a JSON array.
+//
+// argsJSON is either a JSON array, whose elements are decoded into the first function's
+// parameters in order, or any other JSON value which is decoded into the first function's
+// only parameter (e.g. an object decoded into a struct).
+//
+// On failure, the error is returned along with its JSON encoding, for callers to pass it on,
+// e.g. in an HTTP response: an object with the error message, and the kind of the error, which
+// is "stage" for the errors returned by a function, or its panics, and "pipe" for the errors of
+// the pipe itself, e.g. invalid arguments or outputs that can't be encoded. The index of the
+// function is given when known:
+//
+//	{"error":"strconv.Atoi: parsing \"x\": invalid syntax","kind":"stage","stage":1}
+func (p *Pipe) ExecuteJSON(argsJSON []byte) ([]byte, error) {
+	outputs, err := p.executeJSON(argsJSON)
+	if err != nil {
+		return encodeJSONError(err), err
+	}
+	b, err := json.Marshal(outputs)
+	if err != nil {
+		err = fmt.Errorf("encoding outputs: %w", err)
+		return encodeJSONError(err), err
+	}
+	return b, nil
+}
+
+// executeJSON decodes the arguments from argsJSON and executes the pipe, see ExecuteJSON.
+func (p *Pipe) executeJSON(argsJSON []byte) ([]interface{}, error) {
+	if err := p.lock(); err != nil {
+		return nil, err
+	}
+	if len(p.funcs) == 0 {
+		p.mux.Unlock()
+		return nil, errors.New("pipe is empty")
+	}
+	fnType := funcType(p.funcs[0].fn)
+	if fnType == nil {
+		p.mux.Unlock()
+		return nil, errors.New("the first stage's parameters are unknown")
+	}
+	params := p.paramTypes(fnType)
+	p.mux.Unlock()
+
+	args, err := decodeArgs(argsJSON, params)
+	if err != nil {
+		return nil, err
+	}
+	return p.Execute(args...)
+}
+
+// jsonError is the JSON encoding of an error, see ExecuteJSON.
+type jsonError struct {
+	Error string `json:"error"`
+	Kind  string `json:"kind"`
+	Stage *int   `json:"stage,omitempty"`
+}
+
+// encodeJSONError returns the JSON encoding of err, see ExecuteJSON.
+func encodeJSONError(err error) []byte {
+	payload := jsonError{Error: err.Error(), Kind: "pipe"}
+	var stageErr *StageError
+	var tme *TypeMismatchError
+	switch {
+	case errors.As(err, &stageErr):
+		payload.Error, payload.Kind, payload.Stage = stageErr.Err.Error(), "stage", &stageErr.Index
+	case errors.As(err, &tme):
+		payload.Stage = &tme.Index
+	}
+	b, _ := json.Marshal(payload) // A struct of strings and an int can always be encoded.
+	return b
+}
+
+// decodeArgs decodes data into values of the given types.
+func decodeArgs(data []byte, types []reflect.Type) ([]interface{}, error) {
+	raw := []json.RawMessage{data}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' && !isSliceParam(types) {
+		if err := json.Unmarshal(trimmed, &raw); err != nil {
+			return nil, fmt.Errorf("decoding arguments: %w", err)
+		}
+	}
+	if len(raw) != len(types) {
+		return nil, fmt.Errorf("got %d arguments, expected %d", len(raw), len(types))
+	}
+
+	args := make([]interface{}, len(types))
+	for i, t := range types {
+		v := reflect.New(t)
+		if err := json.Unmarshal(raw[i], v.Interface()); err != nil {
+			return nil, fmt.Errorf("decoding argument %d: %w", i, err)
+		}
+		args[i] = v.Elem().Interface()
+	}
+	return args, nil
+}
+
+// isSliceParam reports whether types is a single slice or array, in which case a JSON array
+// is decoded into it rather than spread over the parameters.
+func isSliceParam(types []reflect.Type) bool {
+	return len(types) == 1 && (types[0].Kind() == reflect.Slice || types[0].Kind() == reflect.Array)
+}
@@ -0,0 +1,93 @@
+package pipe
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestPipe_ExecuteJSON(t *testing.T) {
+	type operands struct {
+		A int `json:"a"`
+		B int `json:"b"`
+	}
+	p, err := New(
+		func(o operands) (int, int) { return o.A / o.B, o.A % o.B },
+		func(q, r int) map[string]int { return map[string]int{"q": q, "r": r} },
+	)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	out, err := p.ExecuteJSON([]byte(`{"a":10,"b":3}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := `[{"q":3,"r":1}]`; string(out) != expected {
+		t.Errorf("output mismatch: expected %s, got %s", expected, out)
+	}
+
+	p, err = New(func(a, b float64) float64 { return a * b })
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	out, err = p.ExecuteJSON([]byte(`[2.5, 4]`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := `[10]`; string(out) != expected {
+		t.Errorf("output mismatch: expected %s, got %s", expected, out)
+	}
+
+	if _, err := p.ExecuteJSON([]byte(`["a", 4]`)); err == nil {
+		t.Error("expected an error for an invalid argument")
+	}
+}
+
+func TestPipe_ExecuteJSON_error(t *testing.T) {
+	p, err := New(func(s string) string { return s }, strconv.Atoi, func(n int) int { return n * 2 })
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	for _, test := range []struct {
+		args     string
+		expected map[string]interface{}
+	}{
+		{`"x"`, map[string]interface{}{"error": `strconv.Atoi: parsing "x": invalid syntax`, "kind": "stage", "stage": 1.0}},
+		{`42`, map[string]interface{}{"error": "decoding argument 0: json: cannot unmarshal number into Go value of type string", "kind": "pipe"}},
+	} {
+		out, err := p.ExecuteJSON([]byte(test.args))
+		if err == nil {
+			t.Errorf("%s: expected an error", test.args)
+			continue
+		}
+		var payload map[string]interface{}
+		if err := json.Unmarshal(out, &payload); err != nil {
+			t.Fatalf("%s: invalid error payload %q: %v", test.args, out, err)
+		}
+		if !reflect.DeepEqual(payload, test.expected) {
+			t.Errorf("%s: payload mismatch: expected %v, got %v", test.args, test.expected, payload)
+		}
+	}
+
+	unencodable, err := New(func(s string) func() { return func() {} })
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	out, err := unencodable.ExecuteJSON([]byte(`"x"`))
+	var payload map[string]interface{}
+	if err == nil {
+		t.Error("expected an error for outputs that can't be encoded")
+	} else if err := json.Unmarshal(out, &payload); err != nil || payload["kind"] != "pipe" {
+		t.Errorf("expected a pipe error payload, got %q", out)
+	}
+
+	_, err = p.ExecuteJSON([]byte(`"x"`))
+	var numErr *strconv.NumError
+	if !errors.As(err, &numErr) {
+		t.Errorf("expected the error to wrap a *strconv.NumError, got %v", err)
+	}
+}
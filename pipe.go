@@ -1,114 +1,2956 @@
 package pipe
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"iter"
 	"reflect"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 // Pipe contains the functions that need to be executed in order, where one's outputs are another's inputs (think of unix pipes).
 type Pipe struct {
-	funcs []interface{}
+	funcs []stage
 	mux   sync.Mutex
+
+	settings
+
+	lastMux sync.Mutex // guards last between concurrent runs
+	last    []stageRecord
+
+	// running holds the goroutines executing the pipe, see DetectReentrancy.
+	running goroutineSet
+	// events publishes the events of the executions, see Subscribe.
+	events eventBus
+}
+
+// settings holds the settings of a pipe, which Clone copies.
+type settings struct {
+	retainLast bool
+
+	recoverPanics bool
+	panicHandler  func(index int, recovered interface{}) error
+
+	logger Logger
+
+	forwardErrors bool
+	typeBus       bool
+	ctxValues     map[reflect.Type]interface{}
+	valueBuilder  func(arg interface{}, wantType reflect.Type) (reflect.Value, error)
+
+	outputTransform func([]interface{}) ([]interface{}, error)
+	detectMutation  bool
+	timingHook      func(total time.Duration, stages int, err error)
+
+	dynamicStages    bool
+	maxDynamicStages int // 0 for defaultMaxDynamicStages
+
+	requireStages bool
+	returnType    reflect.Type
+
+	detectReentrancy bool
+	audit            *auditWriter
+	copyInputs       bool
+	tracer           Tracer
+	typeMatcher      func(argType, paramType reflect.Type) bool
+	maxOutputs       int
+	sliceThreading   bool
+	defaultArgs      []interface{}
+}
+
+// Clone returns a copy of the pipe, with the same functions and settings, that can then be
+// changed independently. Retained stage records (see SetRetainLast) aren't copied.
+//
+// The functions and Stages themselves are shared: the state of a Stage, a memoization cache
+// (see AddMemoized) or a circuit breaker (see AddWithBreaker) is common to both pipes.
+func (p *Pipe) Clone() *Pipe {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return &Pipe{funcs: append([]stage{}, p.funcs...), settings: p.settings}
+}
+
+// Fingerprint returns a hash of the structure of the pipe, derived from the type of every
+// function (or Stage) and the output names and tags it was added with, if any. Structurally
+// identical pipes, e.g. clones, have the same fingerprint, while adding, removing or changing a
+// function changes it. Settings and function names are not taken into account.
+func (p *Pipe) Fingerprint() string {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	h := fnv.New64a()
+	for _, s := range p.funcs {
+		fmt.Fprintf(h, "%v|%q|%q\n", reflect.TypeOf(s.fn), s.outputNames, s.tags)
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// defaultMaxDynamicStages is the default maximum number of dynamic functions run by an
+// execution, see AllowDynamicStages.
+const defaultMaxDynamicStages = 100
+
+// Logger is used by a Pipe to report warnings. It is satisfied by *log.Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// PanicError is returned from Execute when panic recovery is enabled and a function panics,
+// unless a custom panic handler is set.
+type PanicError struct {
+	// Index is the position of the panicking function in the pipe.
+	Index int
+	// Value is the value passed to panic.
+	Value interface{}
+	// Stack is the stack trace of the panicking goroutine, as formatted by runtime/debug.Stack,
+	// which shows where the panic originated.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("function %d panicked: %v", e.Index, e.Value)
+}
+
+// ErrStop can be returned by a function, possibly wrapped (see Stop), to gracefully stop the
+// execution of a pipe: Execute then returns the inputs of that function, i.e. the outputs of the
+// previous one, alongside the error.
+//
+// Unlike other errors, which mean the execution failed, ErrStop means it ended early on
+// purpose, so callers should check for it with errors.Is before treating an error as a failure.
+var ErrStop = errors.New("pipe stopped")
+
+// ErrCircuitOpen is returned by a function added with AddWithBreaker while its breaker is open.
+var ErrCircuitOpen = errors.New("circuit open")
+
+// ErrBudgetExceeded is wrapped by the error returned from ExecuteBudgeted when a function
+// exceeds its time budget.
+var ErrBudgetExceeded = errors.New("time budget exceeded")
+
+// ErrReentrant is returned when a function executes the pipe it belongs to, which would
+// otherwise deadlock since the pipe is locked while it executes, see DetectReentrancy.
+var ErrReentrant = errors.New("reentrant Execute detected")
+
+// ErrNoStages is returned by the executions of an empty pipe when RequireStages is enabled.
+var ErrNoStages = errors.New("pipe has no functions")
+
+// ErrTooManyOutputs is wrapped by the error returned from the executions of a pipe when a
+// function returns more outputs than allowed, see SetMaxOutputs.
+var ErrTooManyOutputs = errors.New("too many outputs")
+
+// Stop returns an error wrapping ErrStop with the given message, for functions to gracefully
+// stop the execution of a pipe and explain why.
+func Stop(msg string) error {
+	return fmt.Errorf("%w: %s", ErrStop, msg)
+}
+
+// StageError is returned from Execute when a function returns a non-nil error or panics.
+// It wraps the original error, so errors.Is and errors.As can be used to inspect it.
+type StageError struct {
+	// Index is the position of the failing function in the pipe.
+	Index int
+	// Err is the error returned by the function.
+	Err error
+}
+
+func (e *StageError) Error() string {
+	return fmt.Sprintf("function %d: %v", e.Index, e.Err)
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// TypeMismatchError is returned from Execute when an argument can't be passed to a function
+// because its type doesn't match the parameter's type.
+type TypeMismatchError struct {
+	// Index is the position of the function in the pipe.
+	Index int
+	// ParamIndex is the position of the parameter in the function's signature.
+	ParamIndex int
+	// Expected is the type of the parameter.
+	Expected reflect.Type
+	// Actual is the type of the argument, nil for a nil argument.
+	Actual reflect.Type
+}
+
+func (e *TypeMismatchError) Error() string {
+	return fmt.Sprintf("function %d: argument %d is of type %v, expected %v", e.Index, e.ParamIndex, e.Actual, e.Expected)
+}
+
+// stage is a function (or Stage) of a pipe, with its settings.
+type stage struct {
+	fn interface{}
+	// outputNames are the names of the outputs of fn, see AddNamedOutputs.
+	outputNames []string
+	// memo caches the outputs of fn, see AddMemoized.
+	memo *memoCache
+	// spread makes a single []interface{} output of fn be spread, see AddSpread.
+	spread bool
+	// tags are the tags of the stage, see AddTagged.
+	tags []string
+	// priority orders the stage among the others, see AddWithPriority.
+	priority int
+	// spanName is the name of the span traced around the stage, see AddTraced.
+	spanName string
+}
+
+// memoCache holds the outputs of a memoized function, keyed by its inputs.
+type memoCache struct {
+	keyFunc func(inputs []interface{}) string
+
+	mux     sync.Mutex
+	outputs map[string][]interface{}
+}
+
+func (c *memoCache) get(key string) ([]interface{}, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	outputs, ok := c.outputs[key]
+	return copySlice(outputs), ok
+}
+
+func (c *memoCache) set(key string, outputs []interface{}) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.outputs[key] = copySlice(outputs)
+}
+
+func (c *memoCache) clear() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.outputs = make(map[string][]interface{})
+}
+
+// stageRecord holds the inputs and outputs of a single stage run.
+type stageRecord struct {
+	inputs  []interface{}
+	outputs []interface{}
+	// skipped is true if the stage was skipped rather than run, e.g. by ExecuteRange.
+	skipped bool
+}
+
+// StageInfo describes the position of a function in a pipe.
+//
+// If the first parameter of a function is of type StageInfo, Execute fills it automatically
+// and the remaining parameters are taken from the previous function's outputs.
+type StageInfo struct {
+	// Index is the position of the function in the pipe.
+	Index int
+	// Name is the name of the function, as reported by the runtime.
+	Name string
+}
+
+var stageInfoType = reflect.TypeOf(StageInfo{})
+
+// Lazy is an argument (or output) that is only computed when a function consumes it, e.g. an
+// expensive argument passed to Execute that is only needed by some executions.
+//
+// When a Lazy value is passed to a parameter of any other type than Lazy, it is called and its
+// result is passed instead (a parameter of type Lazy receives the thunk itself, to forward it
+// unevaluated). A Lazy value is called every time it is consumed, and never if it isn't.
+// Validate and Check accept a Lazy value for a parameter of any type.
+type Lazy func() interface{}
+
+var lazyType = reflect.TypeOf(Lazy(nil))
+
+// Stage can be added to a pipe as an alternative to a function, e.g. to carry state in a struct.
+//
+// Run receives the outputs of the previous function (or the arguments passed to Execute) and
+// returns the inputs of the next one. A non-nil error stops the execution like a function's error.
+type Stage interface {
+	Run(inputs []interface{}) (outputs []interface{}, err error)
 }
 
-// New instantiates a new Pipe with initial functions in it.
+// New instantiates a new Pipe with initial functions in it. Stages can be used in place of functions.
 func New(funcs ...interface{}) (*Pipe, error) {
 	p := &Pipe{}
 	for _, f := range funcs {
-		if reflect.TypeOf(f).Kind() != reflect.Func {
+		if err := checkFunc(f); err != nil {
+			return nil, err
+		}
+		p.insert(stage{fn: f})
+	}
+	return p, nil
+}
+
+// NewFromValues instantiates a new Pipe with initial functions held in reflect.Values.
+func NewFromValues(vals ...reflect.Value) (*Pipe, error) {
+	p := &Pipe{}
+	for _, v := range vals {
+		if !v.IsValid() || v.Kind() != reflect.Func || v.IsNil() || !v.CanInterface() {
 			return nil, errors.New("argument is not a function")
 		}
-		p.funcs = append(p.funcs, f)
+		p.insert(stage{fn: v.Interface()})
 	}
 	return p, nil
 }
 
-// Add can be used to insert an additional function to the end of the execution stack.
+// Add can be used to insert an additional function (or Stage) to the end of the execution stack.
+//
+// Add is safe to call concurrently: each function is appended atomically, so none is lost,
+// and concurrent calls end up in the order they acquired the pipe's lock.
 func (p *Pipe) Add(f interface{}) error {
-	if reflect.TypeOf(f).Kind() != reflect.Func {
-		return errors.New("argument is not a function")
+	if err := checkFunc(f); err != nil {
+		return err
 	}
 	p.mux.Lock()
 	defer p.mux.Unlock()
-	p.funcs = append(p.funcs, f)
+	p.insert(stage{fn: f})
 	return nil
 }
 
-// Execute loops through all internal functions and executes them in the order they were added.
+// SetLogger sets the logger used to report warnings. A nil logger disables logging.
+func (p *Pipe) SetLogger(l Logger) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.logger = l
+}
+
+// logf reports a warning through the logger, if any.
+func (p *Pipe) logf(format string, v ...interface{}) {
+	if p.logger != nil {
+		p.logger.Printf(format, v...)
+	}
+}
+
+// Validate checks, using the functions' signatures only, that the outputs of every function
+// can be passed as arguments to the next one.
 //
-// It executes functions one after the other, passing the outputs of one function as arguments
-// to the next (the first function's arguments are the args passed to the Execute function).
+// When a function returns an interface type and the next one expects a concrete type
+// implementing it, the value may or may not match at runtime, depending on its dynamic type.
+// In that case Validate doesn't fail but reports a warning through the logger.
+func (p *Pipe) Validate() error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	for i := 0; i+1 < len(p.funcs); i++ {
+		if err := p.validateBoundary(i, p.funcs[i], p.funcs[i+1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check behaves like Validate, but also checks that args, as they would be passed to Execute,
+// can be passed to the first function: it follows the types of args through the pipe, so it
+// catches the mismatches that depend on the arguments, without executing anything.
 //
-// When a function returns an error and that error is not nil, it will be returned from Execute.
+// A mismatch is reported as a *TypeMismatchError. When a Stage is reached, the types of its
+// outputs are unknown and Check stops there, as it does after a function added with AddSpread.
+func (p *Pipe) Check(args ...interface{}) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	types := make([]reflect.Type, len(args))
+	for i, arg := range args {
+		types[i] = reflect.TypeOf(arg)
+	}
+
+	for i, s := range p.funcs {
+		fnType := funcType(s.fn)
+		if fnType == nil {
+			return nil
+		}
+		if err := p.checkArgs(i, fnType, types); err != nil {
+			return err
+		}
+		if s.spread {
+			return nil
+		}
+		types = p.forwardedTypes(fnType)
+	}
+	return nil
+}
+
+// CanAccept checks, without executing anything, that args can be passed to the first function
+// of the pipe: there must be enough of them, and each must be assignable to the matching
+// parameter. Unlike Check, the rest of the pipe is not checked, so that it stays cheap.
 //
-// Make sure the next function's signature is compatible with the current executing function.
-// The following rules apply:
+// A mismatch is reported as a *TypeMismatchError. Any args are accepted by an empty pipe,
+// unless stages are required (see RequireStages), and by a Stage.
+func (p *Pipe) CanAccept(args ...interface{}) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if len(p.funcs) == 0 {
+		if p.requireStages {
+			return ErrNoStages
+		}
+		return nil
+	}
+	fnType := funcType(p.funcs[0].fn)
+	if fnType == nil {
+		return nil
+	}
+	types := make([]reflect.Type, len(args))
+	for i, arg := range args {
+		types[i] = reflect.TypeOf(arg)
+	}
+	return p.checkArgs(0, fnType, types)
+}
+
+// AssertContract checks, using the functions' signatures only, that the pipe takes arguments of
+// exactly the types in, i.e. the parameters of the first function, and returns outputs of exactly
+// the types out, i.e. the values the last function passes on, or the return type if set (see
+// SetReturnType). This documents and enforces the public signature of a pipe, e.g. in tests.
 //
-//  1. If a function has less arguments than the next one, an error is returned.
-//  2. If a function has more arguments than the next one, only the first arguments thats
-//     match the function's signature will be used.
+// An empty pipe returns its arguments. A contract can't be asserted when an end of the pipe is
+// only known at runtime: a Stage, a function added with AddSpread, or an output transform.
+func (p *Pipe) AssertContract(in []reflect.Type, out []reflect.Type) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if len(p.funcs) == 0 {
+		if !slices.Equal(in, out) {
+			return fmt.Errorf("empty pipe returns its arguments (%s), not (%s)", typeList(in), typeList(out))
+		}
+		return nil
+	}
+
+	first, last := p.funcs[0], p.funcs[len(p.funcs)-1]
+	firstType, lastType := funcType(first.fn), funcType(last.fn)
+	if firstType == nil {
+		return errors.New("arguments of function 0 are only known at runtime")
+	}
+	if params := p.paramTypes(firstType); !slices.Equal(params, in) {
+		return fmt.Errorf("pipe takes arguments (%s), not (%s)", typeList(params), typeList(in))
+	}
+
+	var outs []reflect.Type
+	switch {
+	case p.returnType != nil:
+		outs = []reflect.Type{p.returnType}
+	case p.outputTransform != nil:
+		return errors.New("outputs of the output transform are only known at runtime")
+	case lastType == nil || last.spread:
+		return fmt.Errorf("outputs of function %d are only known at runtime", len(p.funcs)-1)
+	default:
+		outs = p.forwardedTypes(lastType)
+	}
+	if !slices.Equal(outs, out) {
+		return fmt.Errorf("pipe returns outputs (%s), not (%s)", typeList(outs), typeList(out))
+	}
+	return nil
+}
+
+// checkArgs checks that values of the given types can be passed to the function at index i,
+// of type fnType.
+func (p *Pipe) checkArgs(i int, fnType reflect.Type, types []reflect.Type) error {
+	params := p.paramTypes(fnType)
+	if len(types) < len(params) {
+		return fmt.Errorf("function %d takes %d arguments (%s) but gets %d values (%s)", i, len(params), typeList(params), len(types), typeList(types))
+	}
+	for j, param := range params {
+		switch t := types[j]; {
+		case t == nil && isNillable(param):
+		case t != nil && t.AssignableTo(param):
+		case t != nil && fnType.IsVariadic() && j == len(params)-1 && t.AssignableTo(param.Elem()):
+			// The variadic parameter collects the remaining values.
+		case t == lazyType:
+			// The type of a Lazy value is only known once computed.
+		case t != nil && t.Kind() == reflect.Interface && param.Implements(t):
+			p.logf("pipe: value %d (%v) passed to function %d may not hold a %v", j, t, i, param)
+		default:
+			return &TypeMismatchError{Index: i, ParamIndex: j, Expected: param, Actual: t}
+		}
+	}
+	return nil
+}
+
+// validateBoundary checks that the outputs of from, the stage at index i, can be passed as
+// arguments to the next stage to. The error describes both signatures.
+func (p *Pipe) validateBoundary(i int, from, to stage) error {
+	fromType, toType := funcType(from.fn), funcType(to.fn)
+	if fromType == nil || toType == nil || from.spread {
+		// The signature of a Stage, and the outputs of a spread function, are only known at runtime.
+		return nil
+	}
+
+	outs := p.forwardedTypes(fromType)
+	if p.dynamicStages && len(outs) > 0 && outs[0].Kind() == reflect.Func {
+		// The outputs of a dynamic function are only known at runtime.
+		return nil
+	}
+	params := p.paramTypes(toType)
+	mismatch := func(reason string) error {
+		return fmt.Errorf("function %d returns %d values (%s) but function %d takes %d arguments (%s): %s",
+			i, len(outs), typeList(outs), i+1, len(params), typeList(params), reason)
+	}
+	if len(outs) < len(params) {
+		return mismatch("not enough values")
+	}
+	for j, param := range params {
+		switch out := outs[j]; {
+		case out.AssignableTo(param):
+		case toType.IsVariadic() && j == len(params)-1 && out.AssignableTo(param.Elem()):
+			// The variadic parameter collects the remaining values.
+		case out == lazyType:
+			// The type of a Lazy value is only known once computed.
+		case out.Kind() == reflect.Interface && param.Implements(out):
+			p.logf("pipe: output %d of function %d (%v) may not hold a %v expected by function %d", j, i, out, param, i+1)
+		default:
+			return mismatch(fmt.Sprintf("value %d (%v) is not assignable to argument %d (%v)", j, out, j, param))
+		}
+	}
+	return nil
+}
+
+// typeList formats types as a comma-separated list.
+func typeList(types []reflect.Type) string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = t.String()
+	}
+	return strings.Join(names, ", ")
+}
+
+// PipeView is an immutable snapshot of the structure of a pipe, see Snapshot.
+type PipeView struct {
+	types []reflect.Type
+	names []string
+}
+
+// Snapshot returns a view of the functions currently in the pipe. Later changes to the pipe
+// don't affect the view, which is safe to use concurrently.
+func (p *Pipe) Snapshot() PipeView {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	v := PipeView{
+		types: make([]reflect.Type, len(p.funcs)),
+		names: make([]string, len(p.funcs)),
+	}
+	for i, s := range p.funcs {
+		v.types[i] = reflect.TypeOf(s.fn)
+		v.names[i] = funcName(s.fn)
+	}
+	return v
+}
+
+// Len returns the number of functions in the view.
+func (v PipeView) Len() int {
+	return len(v.types)
+}
+
+// Types returns the types of the functions (or Stages) in the view.
+func (v PipeView) Types() []reflect.Type {
+	return append([]reflect.Type{}, v.types...)
+}
+
+// Names returns the names of the functions in the view, as reported by the runtime, or the
+// type names of Stages.
+func (v PipeView) Names() []string {
+	return append([]string{}, v.names...)
+}
+
+// AnalyzeReachability returns the indexes of the functions that can't be reached, judging by the
+// functions' signatures only.
 //
-// The last function's output will also be returned from the Execute function.
-func (p *Pipe) Execute(args ...interface{}) ([]interface{}, error) {
+// A function whose only outputs are errors either fails or forwards nothing usable, so a next
+// function expecting arguments can never run, and neither can the ones after it.
+func (p *Pipe) AnalyzeReachability() []int {
 	p.mux.Lock()
 	defer p.mux.Unlock()
 
-	var inputs []interface{} = args
-	var outputs []interface{}
-	var err error
+	var unreachable []int
+	for i := 1; i < len(p.funcs); i++ {
+		if len(unreachable) > 0 {
+			unreachable = append(unreachable, i)
+			continue
+		}
+		prevType, fnType := funcType(p.funcs[i-1].fn), funcType(p.funcs[i].fn)
+		if prevType != nil && fnType != nil && onlyErrors(prevType) && len(p.paramTypes(fnType)) > 0 {
+			unreachable = append(unreachable, i)
+		}
+	}
+	return unreachable
+}
 
-	for _, fn := range p.funcs {
-		// Determine the expected number of inputs.
-		fnType := reflect.TypeOf(fn)
-		numIn := fnType.NumIn()
+// onlyErrors reports whether all the values a function returns, if any, are errors.
+func onlyErrors(fnType reflect.Type) bool {
+	for _, t := range outTypes(fnType) {
+		if t != errorType {
+			return false
+		}
+	}
+	return fnType.NumOut() > 0
+}
 
-		in := make([]reflect.Value, numIn)
-		for j := 0; j < numIn; j++ {
-			if j < len(inputs) {
-				in[j] = reflect.ValueOf(inputs[j])
-			} else {
-				in[j] = reflect.Zero(reflect.TypeOf(fn).In(j))
-			}
+// FindSinks returns the indexes of the functions that return no values besides errors, judging by
+// their signatures only. Such a sink, e.g. a function writing its inputs somewhere, is usually
+// meant to be last: the next function gets no arguments, unless it takes none.
+func (p *Pipe) FindSinks() []int {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	var sinks []int
+	for i, s := range p.funcs {
+		fnType := funcType(s.fn)
+		if fnType != nil && (fnType.NumOut() == 0 || onlyErrors(fnType)) {
+			sinks = append(sinks, i)
 		}
+	}
+	return sinks
+}
 
-		if len(inputs) < numIn {
-			return nil, fmt.Errorf("not enough arguments for function %v", fnType)
-		} else if len(inputs) > numIn {
-			// Loop through the inputs to determine which ones match the expected types.
-			newIn := make([]reflect.Value, numIn)
-			var j int
-			for i := 0; i < numIn; i++ {
-				if inputs[i] != nil && reflect.TypeOf(inputs[i]).AssignableTo(reflect.TypeOf(in[i].Interface())) {
-					newIn[i] = reflect.ValueOf(inputs[i])
-					j++
-				}
+// IsPure reports whether the pipe looks pure, judging by the functions' signatures only: no
+// function returns an error, nor takes a context or channel argument. Stages, whose signature
+// is only known at runtime, make the pipe impure.
+//
+// This is a structural heuristic, not a semantic guarantee: a pure-looking function can still
+// have side effects or depend on global state. It is meant to guide decisions like memoization.
+func (p *Pipe) IsPure() bool {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	for _, s := range p.funcs {
+		fnType := funcType(s.fn)
+		if fnType == nil {
+			return false
+		}
+		for _, t := range outTypes(fnType) {
+			if t == errorType {
+				return false
 			}
-			if j != numIn {
-				return nil, fmt.Errorf("invalid arguments function %v", fn)
+		}
+		for _, t := range inTypes(fnType) {
+			if t == contextType || t.Kind() == reflect.Chan {
+				return false
 			}
-			in = newIn
 		}
+	}
+	return true
+}
 
-		// Call the function with the determined arguments.
-		out := reflect.ValueOf(fn).Call(in)
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
 
-		// Store the outputs.
-		for _, o := range out {
-			if o.IsValid() {
-				outputs = append(outputs, o.Interface())
-				if o.Type().Name() == "error" && !o.IsNil() {
-					err = o.Interface().(error)
-					return nil, err
-				}
+// EstimateCost returns a rough, heuristic cost of executing the pipe, only meant to compare pipe
+// designs with each other: every function counts for one reflective call plus one per
+// parameter and returned value, which are boxed in interfaces. A Stage counts for one.
+func (p *Pipe) EstimateCost() int {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	var cost int
+	for _, s := range p.funcs {
+		cost++
+		if fnType := funcType(s.fn); fnType != nil {
+			cost += fnType.NumIn() + fnType.NumOut()
+		}
+	}
+	return cost
+}
+
+// DOT returns a Graphviz DOT representation of the pipe, with one node per function labeled
+// with its signature and an edge between consecutive functions labeled with the types that
+// flow from one to the other, which only include errors if they are forwarded (see
+// SetErrorStops).
+func (p *Pipe) DOT() string {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	var b strings.Builder
+	b.WriteString("digraph pipe {\n")
+	for i, s := range p.funcs {
+		fmt.Fprintf(&b, "\tn%d [label=%q];\n", i, reflect.TypeOf(s.fn).String())
+	}
+	for i := 0; i+1 < len(p.funcs); i++ {
+		types := []string{"[]interface {}"}
+		if fnType := funcType(p.funcs[i].fn); fnType != nil {
+			types = nil
+			for _, t := range p.forwardedTypes(fnType) {
+				types = append(types, t.String())
 			}
 		}
+		fmt.Fprintf(&b, "\tn%d -> n%d [label=%q];\n", i, i+1, strings.Join(types, ", "))
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
 
-		// Set the inputs for the next function.
-		inputs = outputs
-		outputs = []interface{}{}
+// forwardedTypes returns the types of the values a function passes to the next one: its
+// outputs, minus the errors unless they are forwarded (they are dropped when nil).
+func (p *Pipe) forwardedTypes(fnType reflect.Type) []reflect.Type {
+	var types []reflect.Type
+	for _, t := range outTypes(fnType) {
+		if t != errorType || p.forwardErrors {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// inTypes returns the types of the parameters of a function.
+func inTypes(fnType reflect.Type) []reflect.Type {
+	types := make([]reflect.Type, fnType.NumIn())
+	for i := range types {
+		types[i] = fnType.In(i)
+	}
+	return types
+}
+
+// outTypes returns the types of the values returned by a function.
+func outTypes(fnType reflect.Type) []reflect.Type {
+	types := make([]reflect.Type, fnType.NumOut())
+	for i := range types {
+		types[i] = fnType.Out(i)
+	}
+	return types
+}
+
+// paramTypes returns the types of the parameters of a function that are taken from the
+// previous function's outputs, i.e. excluding a leading StageInfo and context values.
+func (p *Pipe) paramTypes(fnType reflect.Type) []reflect.Type {
+	return boundParamTypes(fnType, p.ctxValues)
+}
+
+// boundParamTypes behaves like paramTypes, with the given context values bindings.
+func boundParamTypes(fnType reflect.Type, ctxValues map[reflect.Type]interface{}) []reflect.Type {
+	var types []reflect.Type
+	for i := 0; i < fnType.NumIn(); i++ {
+		if i == 0 && fnType.In(i) == stageInfoType {
+			continue
+		}
+		if _, ok := ctxValues[fnType.In(i)]; ok {
+			continue
+		}
+		types = append(types, fnType.In(i))
+	}
+	return types
+}
+
+// checkFunc returns an error if f can't be added to a pipe, i.e. if it is neither a function
+// nor a Stage.
+func checkFunc(f interface{}) error {
+	if _, ok := f.(Stage); ok {
+		return nil
+	}
+	if f == nil || reflect.TypeOf(f).Kind() != reflect.Func {
+		return errors.New("argument is not a function")
+	}
+	return nil
+}
+
+// Len returns the number of functions in the pipe.
+func (p *Pipe) Len() int {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return len(p.funcs)
+}
+
+// AddTyped inserts a function to the end of the execution stack, like Add, but only accepts
+// functions with one parameter and one output, checked at compile time, whose exact types are
+// then used by Validate.
+func AddTyped[In, Out any](p *Pipe, f func(In) Out) error {
+	if f == nil {
+		return errors.New("function is nil")
+	}
+	return p.Add(f)
+}
+
+// GetOutput returns the value at the given index of outputs, as returned by Execute, as a T.
+// It fails if the index is out of range or the value isn't a T. A nil value is returned as the
+// zero value of T if T is nillable, e.g. an interface or pointer type.
+func GetOutput[T any](outputs []interface{}, index int) (T, error) {
+	var zero T
+	if index < 0 || index >= len(outputs) {
+		return zero, fmt.Errorf("output %d out of range of %d outputs", index, len(outputs))
+	}
+	if outputs[index] == nil {
+		if t := reflect.TypeOf(&zero).Elem(); !isNillable(t) {
+			return zero, fmt.Errorf("output %d is nil, expected %v", index, t)
+		}
+		return zero, nil
+	}
+	v, ok := outputs[index].(T)
+	if !ok {
+		return zero, fmt.Errorf("output %d is of type %T, expected %v", index, outputs[index], reflect.TypeOf(&zero).Elem())
 	}
+	return v, nil
+}
+
+// AddNamedOutputs inserts a function to the end of the execution stack, naming the values it
+// returns (its nil errors excluded), so that ExecuteIntoMap can return them by name when it is
+// the last function of the pipe.
+func (p *Pipe) AddNamedOutputs(f interface{}, names ...string) error {
+	if err := checkFunc(f); err != nil {
+		return err
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if fnType := funcType(f); fnType != nil && len(p.forwardedTypes(fnType)) != len(names) {
+		return fmt.Errorf("got %d names for the outputs of function %v", len(names), fnType)
+	}
+	p.insert(stage{fn: f, outputNames: names})
+	return nil
+}
 
-	return inputs, err
+// AddWithPriority inserts a function (or Stage) in the execution stack according to its
+// priority, instead of at the end: the functions run in increasing order of priority, those
+// with the same priority in the order they were added. Functions added otherwise have priority
+// 0, so that a negative priority runs f before them and a positive one after them.
+//
+// This changes the effective order of the functions, which is the one of every index, e.g. in
+// errors or StagesByTag, and of the outputs and inputs of consecutive functions.
+func (p *Pipe) AddWithPriority(f interface{}, priority int) error {
+	if err := checkFunc(f); err != nil {
+		return err
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.insert(stage{fn: f, priority: priority})
+	return nil
+}
+
+// insert inserts s in the execution stack, after the stages of lower or equal priority.
+func (p *Pipe) insert(s stage) {
+	i := len(p.funcs)
+	for i > 0 && p.funcs[i-1].priority > s.priority {
+		i--
+	}
+	p.funcs = slices.Insert(p.funcs, i, s)
+}
+
+// AddMemoized inserts a function to the end of the execution stack whose outputs are cached:
+// when the key returned by keyFunc for its inputs was already seen, the cached outputs are
+// used instead of calling f. Only successful calls are cached. f should therefore be pure.
+//
+// The cache grows with every distinct key until cleared with ClearMemoCache.
+func (p *Pipe) AddMemoized(f interface{}, keyFunc func(inputs []interface{}) string) error {
+	if err := checkFunc(f); err != nil {
+		return err
+	}
+	if keyFunc == nil {
+		return errors.New("key function is nil")
+	}
+	memo := &memoCache{keyFunc: keyFunc}
+	memo.clear()
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.insert(stage{fn: f, memo: memo})
+	return nil
+}
+
+// AddTagged inserts a function (or Stage) to the end of the execution stack with the given
+// tags, e.g. "io" or "cpu", to find it later with StagesByTag.
+func (p *Pipe) AddTagged(f interface{}, tags ...string) error {
+	if err := checkFunc(f); err != nil {
+		return err
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.insert(stage{fn: f, tags: append([]string{}, tags...)})
+	return nil
+}
+
+// StagesByTag returns the indexes of the functions added with the given tag, in order.
+func (p *Pipe) StagesByTag(tag string) []int {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	var indexes []int
+	for i, s := range p.funcs {
+		if s.hasTag(tag) {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// hasTag reports whether the stage has the given tag.
+func (s stage) hasTag(tag string) bool {
+	for _, t := range s.tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// AddMultiplex inserts a stage to the end of the execution stack that runs every function (or
+// Stage) of fs with the same inputs, one after the other, and passes their outputs combined to
+// the next function: the outputs of fs[0] first, then the ones of fs[1], and so on. Like for
+// the next function of a pipe, each function takes the first inputs matching its parameters.
+//
+// If a function fails, Execute returns its error and the next functions of fs don't run.
+func (p *Pipe) AddMultiplex(fs ...interface{}) error {
+	if len(fs) == 0 {
+		return errors.New("no functions to multiplex")
+	}
+	for _, f := range fs {
+		if err := checkFunc(f); err != nil {
+			return err
+		}
+	}
+	return p.AddStage(&multiplexStage{fns: append([]interface{}{}, fs...)})
+}
+
+// AddParallel behaves like AddMultiplex, but runs the functions of fs concurrently, each in its
+// own goroutine, and waits for all of them before passing their outputs combined, in the order
+// of fs, to the next function. If functions fail, Execute returns the error of the first one
+// in fs, once they all returned.
+//
+// The functions of fs must be independent of each other, and safe to call concurrently with
+// the same inputs, which they must not mutate.
+func (p *Pipe) AddParallel(fs ...interface{}) error {
+	if len(fs) == 0 {
+		return errors.New("no functions to run in parallel")
+	}
+	for _, f := range fs {
+		if err := checkFunc(f); err != nil {
+			return err
+		}
+	}
+	return p.AddStage(&multiplexStage{fns: append([]interface{}{}, fs...), parallel: true})
+}
+
+// multiplexStage is the Stage inserted by AddMultiplex or AddParallel. Pipes run its functions
+// with their settings, see runMultiplex.
+type multiplexStage struct {
+	fns      []interface{}
+	parallel bool
+}
+
+func (m *multiplexStage) Run(inputs []interface{}) ([]interface{}, error) {
+	return (&Pipe{}).runMultiplex(&run{ctx: context.Background()}, 0, m, inputs)
+}
+
+// runMultiplex runs the functions of m, at the given index, with the inputs.
+func (p *Pipe) runMultiplex(r *run, index int, m *multiplexStage, inputs []interface{}) ([]interface{}, error) {
+	if m.parallel {
+		return p.runParallel(r, index, m, inputs)
+	}
+	var outputs []interface{}
+	for _, fn := range m.fns {
+		out, err := p.runFunc(r, index, fn, inputs)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, out...)
+	}
+	return outputs, nil
+}
+
+// AddGuard inserts a guard to the end of the execution stack: pred is called with the outputs
+// of the previous function, or the arguments of Execute, and the inputs are forwarded unchanged
+// to the next function if it returns true. Otherwise the execution stops there, and returns the
+// inputs of the guard as its outputs, which are transformed like any outputs.
+//
+// If pred returns an error, or panics while panics are recovered, the execution also stops, and
+// returns the inputs of the guard with a *StageError wrapping the error.
+func (p *Pipe) AddGuard(pred func(inputs []interface{}) (bool, error)) error {
+	if pred == nil {
+		return errors.New("guard predicate is nil")
+	}
+	return p.AddStage(&guardStage{pred: pred})
+}
+
+// errGuardClosed is returned by a guard whose predicate returned false, see AddGuard.
+var errGuardClosed = errors.New("guard closed")
+
+// guardStage is the Stage inserted by AddGuard. Pipes run it with their settings, see runGuard.
+type guardStage struct {
+	pred func(inputs []interface{}) (bool, error)
+}
+
+func (g *guardStage) Run(inputs []interface{}) ([]interface{}, error) {
+	return (&Pipe{}).runGuard(&run{ctx: context.Background()}, 0, g, inputs)
+}
+
+// runGuard runs the guard g, at the given index, with the inputs. It returns the inputs, with
+// errGuardClosed if the guard stops the execution.
+func (p *Pipe) runGuard(r *run, index int, g *guardStage, inputs []interface{}) ([]interface{}, error) {
+	var pass bool
+	var predErr error
+	err := p.call(r, index, func() error {
+		pass, predErr = g.pred(copySlice(inputs))
+		return nil
+	})
+	if err == nil {
+		err = predErr
+	}
+	if err != nil {
+		return inputs, &StageError{Index: index, Err: err}
+	}
+	if !pass {
+		return inputs, errGuardClosed
+	}
+	return inputs, nil
+}
+
+// runParallel runs the functions of m concurrently, at the given index, with the inputs.
+// A panic that isn't recovered by runFunc is raised again in the calling goroutine.
+func (p *Pipe) runParallel(r *run, index int, m *multiplexStage, inputs []interface{}) ([]interface{}, error) {
+	results := make([]Result, len(m.fns))
+	panics := make([]interface{}, len(m.fns))
+	var wg sync.WaitGroup
+	for i, fn := range m.fns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				panics[i] = recover()
+			}()
+			if r.detectReentrancy {
+				id := goroutineID()
+				p.running.add(id)
+				defer p.running.remove(id)
+			}
+			out, err := p.runFunc(r, index, fn, inputs)
+			results[i] = Result{Outputs: out, Err: err}
+		}()
+	}
+	wg.Wait()
+	for _, v := range panics {
+		if v != nil {
+			panic(v)
+		}
+	}
+
+	var outputs []interface{}
+	for _, res := range results {
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		outputs = append(outputs, res.Outputs...)
+	}
+	return outputs, nil
+}
+
+// AddSpread inserts a function to the end of the execution stack whose outputs are spread:
+// if f returns a single []interface{} (nil errors excluded), its elements are passed to the
+// next function as individual arguments rather than as one slice.
+//
+// Only one level is spread: elements that are themselves slices are passed as is. Since the
+// number of spread values is only known at runtime, Validate doesn't check the next function
+// against f.
+func (p *Pipe) AddSpread(f interface{}) error {
+	if err := checkFunc(f); err != nil {
+		return err
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.insert(stage{fn: f, spread: true})
+	return nil
+}
+
+// ClearMemoCache clears the caches of the functions added with AddMemoized.
+func (p *Pipe) ClearMemoCache() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	for _, s := range p.funcs {
+		if s.memo != nil {
+			s.memo.clear()
+		}
+	}
+}
+
+// Reset clears the state the executions of the pipe leave behind: the caches of the functions
+// added with AddMemoized and the retained stage records (see SetRetainLast), so that the next
+// execution starts afresh. The functions and settings are kept, as is the state of Stages and
+// circuit breakers (see AddWithBreaker), which belongs to them.
+func (p *Pipe) Reset() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	for _, s := range p.funcs {
+		if s.memo != nil {
+			s.memo.clear()
+		}
+	}
+	p.lastMux.Lock()
+	defer p.lastMux.Unlock()
+	p.last = nil
+}
+
+// AddStage inserts a Stage to the end of the execution stack.
+func (p *Pipe) AddStage(s Stage) error {
+	if s == nil {
+		return errors.New("stage is nil")
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.insert(stage{fn: s})
+	return nil
+}
+
+// AddRaw inserts a function to the end of the execution stack that receives the outputs of the
+// previous function, or the arguments of Execute, as they are, and whose outputs are passed as
+// they are to the next one. Unlike the functions added with Add, f is called directly, without
+// reflection, with a copy of the values: a pipe of raw functions only threads slices.
+//
+// Like a Stage, whose signature is only known at runtime, a raw function isn't checked by
+// Validate. Its error is handled like the error returned by a function.
+func (p *Pipe) AddRaw(f func(inputs []interface{}) ([]interface{}, error)) error {
+	if f == nil {
+		return errors.New("function is nil")
+	}
+	return p.AddStage(rawStage(f))
+}
+
+// rawStage is a function added with AddRaw.
+type rawStage func(inputs []interface{}) ([]interface{}, error)
+
+func (f rawStage) Run(inputs []interface{}) ([]interface{}, error) {
+	return f(inputs)
+}
+
+// Shift removes the first function of the pipe and returns it.
+func (p *Pipe) Shift() (interface{}, error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if len(p.funcs) == 0 {
+		return nil, errors.New("pipe is empty")
+	}
+	f := p.funcs[0].fn
+	p.funcs = p.funcs[1:]
+	return f, nil
+}
+
+// Pop removes the last function of the pipe and returns it.
+func (p *Pipe) Pop() (interface{}, error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if len(p.funcs) == 0 {
+		return nil, errors.New("pipe is empty")
+	}
+	f := p.funcs[len(p.funcs)-1].fn
+	p.funcs = p.funcs[:len(p.funcs)-1]
+	return f, nil
+}
+
+// SetFuncs replaces all the functions of the pipe at once. If any argument is not a function,
+// an error is returned and the pipe is left unchanged.
+func (p *Pipe) SetFuncs(funcs ...interface{}) error {
+	for _, f := range funcs {
+		if err := checkFunc(f); err != nil {
+			return err
+		}
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.funcs = make([]stage, len(funcs))
+	for i, f := range funcs {
+		p.funcs[i] = stage{fn: f}
+	}
+	return nil
+}
+
+// AddWithFallback inserts a function to the end of the execution stack that, if primary
+// returns a non-nil error, calls fallback with the same arguments and uses its outputs instead.
+//
+// fallback must have the same signature as primary. If fallback also fails, its error is
+// returned from Execute.
+func (p *Pipe) AddWithFallback(primary, fallback interface{}) error {
+	for _, f := range []interface{}{primary, fallback} {
+		if err := checkFunc(f); err != nil {
+			return err
+		}
+		if funcType(f) == nil {
+			return errors.New("a Stage can't have a fallback")
+		}
+	}
+	if reflect.TypeOf(primary) != reflect.TypeOf(fallback) {
+		return fmt.Errorf("fallback %v doesn't match the signature of %v", reflect.TypeOf(fallback), reflect.TypeOf(primary))
+	}
+
+	pv, fv := reflect.ValueOf(primary), reflect.ValueOf(fallback)
+	f := reflect.MakeFunc(pv.Type(), func(in []reflect.Value) []reflect.Value {
+		out := callValue(pv, in)
+		if firstError(out) != nil {
+			return callValue(fv, in)
+		}
+		return out
+	})
+	return p.Add(f.Interface())
+}
+
+// Identity returns a Stage forwarding its inputs unchanged, whatever their number and types.
+// Optimize removes it from a pipe.
+//
+// Since it is a Stage, its signature is only known at runtime: Validate doesn't check the
+// functions on either side of it against each other, and Check stops at it.
+func Identity() interface{} {
+	return identityStage{}
+}
+
+// identityStage is the Stage returned by Identity.
+type identityStage struct{}
+
+func (identityStage) Run(inputs []interface{}) ([]interface{}, error) {
+	return inputs, nil
+}
+
+// Optimize removes the stages that don't change the outputs of the pipe, i.e. the ones
+// returned by Identity.
+//
+// Other stages are kept as is, even when they only adapt values between their neighbors:
+// adjacent adapters aren't collapsed, since the pipe can't tell them from functions with
+// side effects.
+func (p *Pipe) Optimize() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	var funcs []stage
+	for _, s := range p.funcs {
+		if _, ok := s.fn.(identityStage); ok {
+			continue
+		}
+		funcs = append(funcs, s)
+	}
+	p.funcs = funcs
+}
+
+// Dedup removes the functions that are identical to the one right before them, so that a
+// function accidentally added twice in a row only runs once. Non-consecutive duplicates are kept.
+//
+// Functions are compared by their code pointer, so closures created by the same function
+// literal are considered identical. Functions made by reflect.MakeFunc, such as the wrappers
+// inserted by AddWithFallback or AddWithBreaker, are never considered identical.
+func (p *Pipe) Dedup() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	var funcs []stage
+	for i, s := range p.funcs {
+		if i > 0 && sameFunc(s.fn, p.funcs[i-1].fn) {
+			continue
+		}
+		funcs = append(funcs, s)
+	}
+	p.funcs = funcs
+}
+
+// AddKeyword inserts a function to the end of the execution stack that takes its arguments
+// from a map[string]interface{} returned by the previous function (or passed to Execute),
+// the i-th parameter of f being bound to the value stored at paramNames[i].
+//
+// The map must contain a value of the right type for every name, otherwise Execute
+// returns an error. A nil value is accepted for a pointer, interface, map, slice, func or chan
+// parameter. f can't be variadic.
+func (p *Pipe) AddKeyword(f interface{}, paramNames []string) error {
+	if err := checkFunc(f); err != nil {
+		return err
+	}
+	fnType := funcType(f)
+	if fnType == nil {
+		return errors.New("a Stage can't take keyword arguments")
+	}
+	if fnType.IsVariadic() {
+		return errors.New("a variadic function can't take keyword arguments")
+	}
+	if len(paramNames) != fnType.NumIn() {
+		return fmt.Errorf("got %d parameter names for function %v", len(paramNames), fnType)
+	}
+
+	// The wrapper needs to be able to return an error for a missing or mismatched key.
+	outs := withErrorOut(fnType)
+
+	fv := reflect.ValueOf(f)
+	mapType := reflect.TypeOf(map[string]interface{}{})
+	wrapperType := reflect.FuncOf([]reflect.Type{mapType}, outs, false)
+	wrapper := reflect.MakeFunc(wrapperType, func(args []reflect.Value) []reflect.Value {
+		m := args[0].Interface().(map[string]interface{})
+		in := make([]reflect.Value, len(paramNames))
+		for i, name := range paramNames {
+			v, ok := m[name]
+			switch t := fnType.In(i); {
+			case ok && v == nil && isNillable(t):
+				in[i] = reflect.Zero(t)
+			case ok && v != nil && reflect.TypeOf(v).AssignableTo(t):
+				in[i] = reflect.ValueOf(v)
+			default:
+				return errorResult(outs, fmt.Errorf("missing or invalid value for parameter %q of function %v", name, fnType))
+			}
+		}
+		return padErrorOut(fv.Call(in), outs)
+	})
+	return p.Add(wrapper.Interface())
+}
+
+// AddWithMapping inserts a function to the end of the execution stack whose parameters are
+// taken from its inputs in the given order, rather than in their own order: the i-th parameter
+// of f gets the input at index order[i], e.g. an order of [1, 0] swaps two inputs. An input can
+// be passed to several parameters of the same type, and inputs not in order are ignored.
+//
+// The last parameter of a variadic function takes its variadic arguments as a single slice input.
+func (p *Pipe) AddWithMapping(f interface{}, order []int) error {
+	if err := checkFunc(f); err != nil {
+		return err
+	}
+	fnType := funcType(f)
+	if fnType == nil {
+		return errors.New("a Stage can't have its inputs mapped")
+	}
+	if len(order) != fnType.NumIn() {
+		return fmt.Errorf("got %d input indexes for function %v", len(order), fnType)
+	}
+
+	// The wrapper takes as many inputs as needed to reach the highest index, those that aren't
+	// passed to f being of any type.
+	var params []reflect.Type
+	for i, k := range order {
+		if k < 0 {
+			return fmt.Errorf("invalid input index %d for parameter %d", k, i)
+		}
+		for len(params) <= k {
+			params = append(params, nil)
+		}
+		if t := fnType.In(i); params[k] == nil {
+			params[k] = t
+		} else if params[k] != t {
+			return fmt.Errorf("input %d can't be passed to parameters of types %v and %v", k, params[k], t)
+		}
+	}
+	for k, t := range params {
+		if t == nil {
+			params[k] = reflect.TypeOf((*interface{})(nil)).Elem()
+		}
+	}
+
+	fv := reflect.ValueOf(f)
+	wrapperType := reflect.FuncOf(params, outTypes(fnType), false)
+	wrapper := reflect.MakeFunc(wrapperType, func(args []reflect.Value) []reflect.Value {
+		in := make([]reflect.Value, len(order))
+		for i, k := range order {
+			in[i] = args[k]
+		}
+		return callValue(fv, in)
+	})
+	return p.Add(wrapper.Interface())
+}
+
+// AddWithBreaker inserts a function to the end of the execution stack, protected by a circuit
+// breaker: once f returns a non-nil error threshold times in a row, it isn't called anymore for
+// the cooldown duration and Execute fails fast with ErrCircuitOpen instead. After the cooldown,
+// f is called again: if it succeeds, the breaker is reset, otherwise it opens again.
+//
+// If f doesn't return an error as its last value, the inserted function returns an additional error.
+func (p *Pipe) AddWithBreaker(f interface{}, threshold int, cooldown time.Duration) error {
+	if err := checkFunc(f); err != nil {
+		return err
+	}
+	fnType := funcType(f)
+	if fnType == nil {
+		return errors.New("a Stage can't have a breaker")
+	}
+	if threshold <= 0 {
+		return errors.New("threshold must be positive")
+	}
+
+	outs := withErrorOut(fnType)
+	wrapperType := reflect.FuncOf(inTypes(fnType), outs, fnType.IsVariadic())
+
+	var (
+		mux       sync.Mutex
+		failures  int
+		openUntil time.Time
+	)
+	fv := reflect.ValueOf(f)
+	wrapper := reflect.MakeFunc(wrapperType, func(in []reflect.Value) []reflect.Value {
+		mux.Lock()
+		if failures >= threshold && time.Now().Before(openUntil) {
+			mux.Unlock()
+			return errorResult(outs, ErrCircuitOpen)
+		}
+		mux.Unlock()
+
+		out := callValue(fv, in)
+
+		mux.Lock()
+		defer mux.Unlock()
+		if firstError(out) != nil {
+			failures++
+			if failures >= threshold {
+				openUntil = time.Now().Add(cooldown)
+			}
+		} else {
+			failures = 0
+		}
+		return padErrorOut(out, outs)
+	})
+	return p.Add(wrapper.Interface())
+}
+
+// AddWithErrorMap inserts a function to the end of the execution stack whose non-nil errors
+// are replaced by the result of mapErr before being returned from Execute, e.g. to translate
+// them into domain errors. When mapErr returns nil, the error is dropped like any nil error and
+// the execution continues with the other values returned by f.
+func (p *Pipe) AddWithErrorMap(f interface{}, mapErr func(error) error) error {
+	if err := checkFunc(f); err != nil {
+		return err
+	}
+	fnType := funcType(f)
+	if fnType == nil {
+		return errors.New("a Stage can't have an error map")
+	}
+	if mapErr == nil {
+		return errors.New("error map is nil")
+	}
+	var returnsError bool
+	for _, t := range outTypes(fnType) {
+		returnsError = returnsError || t == errorType
+	}
+	if !returnsError {
+		return fmt.Errorf("function %v doesn't return an error", fnType)
+	}
+
+	fv := reflect.ValueOf(f)
+	wrapper := reflect.MakeFunc(fnType, func(in []reflect.Value) []reflect.Value {
+		out := callValue(fv, in)
+		for i, o := range out {
+			if o.Type() == errorType && !o.IsNil() {
+				err := mapErr(o.Interface().(error))
+				out[i] = reflect.ValueOf(&err).Elem()
+			}
+		}
+		return out
+	})
+	return p.Add(wrapper.Interface())
+}
+
+// AddWithContext inserts a function to the end of the execution stack whose non-nil errors
+// are wrapped with contextMsg, as "contextMsg: err", so that a failing execution tells what it
+// was doing. Errors wrapped by nested pipes keep their own context, building a breadcrumb.
+func (p *Pipe) AddWithContext(f interface{}, contextMsg string) error {
+	return p.AddWithErrorMap(f, func(err error) error {
+		return fmt.Errorf("%s: %w", contextMsg, err)
+	})
+}
+
+// callValue calls the function fv with in, whose last value holds the variadic arguments
+// in a slice if fv is variadic, like the arguments built by buildArgs or received by a
+// reflect.MakeFunc wrapper of the same signature.
+func callValue(fv reflect.Value, in []reflect.Value) []reflect.Value {
+	if fv.Type().IsVariadic() {
+		return fv.CallSlice(in)
+	}
+	return fv.Call(in)
+}
+
+// withErrorOut returns the output types of a function, with an additional error if the last
+// one isn't already an error, so that a wrapper of the function can report its own errors.
+func withErrorOut(fnType reflect.Type) []reflect.Type {
+	outs := outTypes(fnType)
+	if len(outs) == 0 || outs[len(outs)-1] != errorType {
+		outs = append(outs, errorType)
+	}
+	return outs
+}
+
+// padErrorOut appends a nil error to out if it lacks the additional error of outs.
+func padErrorOut(out []reflect.Value, outs []reflect.Type) []reflect.Value {
+	if len(out) < len(outs) {
+		out = append(out, reflect.Zero(errorType))
+	}
+	return out
+}
+
+// errorResult returns zero values for outs, except for the last one, which is err.
+func errorResult(outs []reflect.Type, err error) []reflect.Value {
+	out := make([]reflect.Value, len(outs))
+	for i, t := range outs {
+		out[i] = reflect.Zero(t)
+	}
+	out[len(out)-1] = reflect.ValueOf(&err).Elem()
+	return out
+}
+
+// Execute loops through all internal functions and executes them in the order they were added.
+//
+// It executes functions one after the other, passing the outputs of one function as arguments
+// to the next (the first function's arguments are the args passed to the Execute function).
+//
+// When a function returns an error and that error is not nil, it will be returned from Execute,
+// wrapped in a *StageError. Nil errors are not passed to the next function, e.g. the outputs of
+// a func() (int, string, error) can be passed to a func(int, string).
+//
+// Make sure the next function's signature is compatible with the current executing function.
+// The following rules apply:
+//
+//  1. If a function has less arguments than the next one, an error is returned.
+//  2. If a function has more arguments than the next one, only the first arguments thats
+//     match the function's signature will be used.
+//
+// The last function's output will also be returned from the Execute function.
+//
+// A function must not execute the pipe it belongs to, since the pipe is locked while it
+// executes: such a reentrant execution deadlocks, unless detected with DetectReentrancy.
+func (p *Pipe) Execute(args ...interface{}) ([]interface{}, error) {
+	if err := p.lock(); err != nil {
+		return nil, err
+	}
+	defer p.mux.Unlock()
+	return p.execute(context.Background(), p.withDefaults(args), execOptions{})
+}
+
+// ExecutePartial behaves like Execute, but when a function fails, it returns the outputs of the
+// last function that succeeded alongside the error, or args if the first one fails, so that the
+// work already done can be salvaged. These outputs aren't transformed (see SetOutputTransform).
+func (p *Pipe) ExecutePartial(args ...interface{}) ([]interface{}, error) {
+	if err := p.lock(); err != nil {
+		return nil, err
+	}
+	defer p.mux.Unlock()
+	return p.execute(context.Background(), p.withDefaults(args), execOptions{salvage: true})
+}
+
+// ExecuteSlice behaves like Execute, using the elements of args as the arguments of the first
+// function. It is equivalent to Execute(args...), and makes it explicit that args holds the
+// arguments: to pass a []interface{} as the only argument, use Execute(args) instead.
+func (p *Pipe) ExecuteSlice(args []interface{}) ([]interface{}, error) {
+	return p.Execute(args...)
+}
+
+// ExecuteStruct behaves like Execute, using the fields of the struct s (or pointer to a struct),
+// in declaration order, as the arguments of the first function. Reordering the fields of the
+// struct therefore changes the arguments.
+//
+// All the fields must be exported, since unexported fields can't be accessed through
+// reflection: a struct with an unexported field is rejected before executing anything.
+func (p *Pipe) ExecuteStruct(s interface{}) ([]interface{}, error) {
+	v := reflect.ValueOf(s)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("argument is not a struct: %T", s)
+	}
+
+	args := make([]interface{}, v.NumField())
+	for i := range args {
+		if field := v.Type().Field(i); !field.IsExported() {
+			return nil, fmt.Errorf("field %s of %v is unexported and can't be used as an argument", field.Name, v.Type())
+		}
+		args[i] = v.Field(i).Interface()
+	}
+	return p.Execute(args...)
+}
+
+// ExecuteWithPool behaves like Execute, but takes the slices holding the arguments of the
+// functions, which Execute otherwise allocates for every function, from pool and puts them back
+// once the functions return, to reduce allocations when the pipe is executed repeatedly.
+//
+// pool must only hold the *[]reflect.Value slices put by ExecuteWithPool: it should be
+// dedicated to that use, its New function being optional. It can be shared between pipes and
+// concurrent executions. The slices never escape from the execution: functions receive copies
+// of the arguments and nothing they return refers to the slices.
+func (p *Pipe) ExecuteWithPool(pool *sync.Pool, args ...interface{}) ([]interface{}, error) {
+	if err := p.lock(); err != nil {
+		return nil, err
+	}
+	defer p.mux.Unlock()
+	return p.execute(context.Background(), p.withDefaults(args), execOptions{pool: pool})
+}
+
+// ExecuteRange behaves like Execute, but only runs the functions at the indexes in [from, to),
+// args being the arguments of the function at index from. It is mostly useful to test a segment
+// of a pipe in isolation. The output transform and return type only apply to a range ending
+// with the last function.
+func (p *Pipe) ExecuteRange(from, to int, args ...interface{}) ([]interface{}, error) {
+	if err := p.lock(); err != nil {
+		return nil, err
+	}
+	defer p.mux.Unlock()
+
+	if from < 0 || to < from || to > len(p.funcs) {
+		return nil, fmt.Errorf("invalid range [%d, %d) for a pipe of %d functions", from, to, len(p.funcs))
+	}
+	return p.execute(context.Background(), p.withDefaults(args), execOptions{
+		only:    func(index int) bool { return index >= from && index < to },
+		partial: to < len(p.funcs),
+	})
+}
+
+// ExecuteTagged behaves like Execute, but only runs the functions added with the given tag
+// (see AddTagged), in order, the outputs of one being passed to the next tagged one. The
+// other functions are skipped. The output transform and return type only apply if the last
+// function is tagged.
+func (p *Pipe) ExecuteTagged(tag string, args ...interface{}) ([]interface{}, error) {
+	if err := p.lock(); err != nil {
+		return nil, err
+	}
+	defer p.mux.Unlock()
+	return p.execute(context.Background(), p.withDefaults(args), execOptions{
+		only:    func(index int) bool { return p.funcs[index].hasTag(tag) },
+		partial: len(p.funcs) > 0 && !p.funcs[len(p.funcs)-1].hasTag(tag),
+	})
+}
+
+// ExecuteIntoMap behaves like Execute, but returns the outputs keyed by the names given to
+// the last function with AddNamedOutputs.
+func (p *Pipe) ExecuteIntoMap(args ...interface{}) (map[string]interface{}, error) {
+	if err := p.lock(); err != nil {
+		return nil, err
+	}
+	defer p.mux.Unlock()
+
+	if len(p.funcs) == 0 || p.funcs[len(p.funcs)-1].outputNames == nil {
+		return nil, errors.New("the outputs of the last function are not named")
+	}
+	names := p.funcs[len(p.funcs)-1].outputNames
+
+	out, err := p.execute(context.Background(), p.withDefaults(args), execOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if len(out) != len(names) {
+		return nil, fmt.Errorf("got %d outputs for %d names", len(out), len(names))
+	}
+	m := make(map[string]interface{}, len(names))
+	for i, name := range names {
+		m[name] = out[i]
+	}
+	return m, nil
+}
+
+// ExecuteContext behaves like Execute, but checks ctx before executing each function and stops
+// with an error wrapping ctx.Err() once ctx is done.
+//
+// Functions are not interrupted while they run: a function that needs to honor the
+// cancellation itself should receive ctx as one of its arguments.
+func (p *Pipe) ExecuteContext(ctx context.Context, args ...interface{}) ([]interface{}, error) {
+	if err := p.lock(); err != nil {
+		return nil, err
+	}
+	defer p.mux.Unlock()
+	return p.execute(ctx, p.withDefaults(args), execOptions{})
+}
+
+// ExecuteTimeout runs ExecuteContext with a child of ctx that is done after the duration d.
+func (p *Pipe) ExecuteTimeout(ctx context.Context, d time.Duration, args ...interface{}) ([]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+	return p.ExecuteContext(ctx, args...)
+}
+
+// ExecuteUntil executes the pipe repeatedly, passing each run's outputs as the arguments of the
+// next run, until pred returns true for the outputs of a run. Those outputs are then returned.
+//
+// An error is returned if pred is still false after maxIter runs.
+func (p *Pipe) ExecuteUntil(pred func(outputs []interface{}) bool, maxIter int, args ...interface{}) ([]interface{}, error) {
+	if err := p.lock(); err != nil {
+		return nil, err
+	}
+	defer p.mux.Unlock()
+
+	args = p.withDefaults(args)
+	for i := 0; i < maxIter; i++ {
+		out, err := p.execute(context.Background(), args, execOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("iteration %d: %w", i, err)
+		}
+		if pred(out) {
+			return out, nil
+		}
+		args = out
+	}
+	return nil, fmt.Errorf("condition not satisfied after %d iterations", maxIter)
+}
+
+// Result holds the outputs and error of a single run of a pipe.
+type Result struct {
+	Outputs []interface{}
+	Err     error
+}
+
+// ExecuteEachConcurrent executes the pipe once per item, passing the item as the only argument,
+// using up to workers goroutines. It returns the result of every item, in the order of items.
+//
+// A failing or panicking item doesn't affect the others: panics are always recovered, as if
+// SetRecoverPanics was enabled, and reported in the item's Result.
+//
+// The functions of the pipe must be safe to call concurrently.
+func (p *Pipe) ExecuteEachConcurrent(items []interface{}, workers int) []Result {
+	if workers <= 0 {
+		workers = 1
+	}
+
+	if err := p.lock(); err != nil {
+		return failAll(len(items), err)
+	}
+	defer p.mux.Unlock()
+
+	results := make([]Result, len(items))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				out, err := p.execute(context.Background(), []interface{}{items[i]}, execOptions{recoverPanics: true})
+				results[i] = Result{Outputs: out, Err: err}
+			}
+		}()
+	}
+	for i := range items {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return results
+}
+
+// Go executes the pipe with args in a new goroutine, and calls done with the outputs and the
+// error of the execution, exactly once, when it completes. Go doesn't wait for it.
+//
+// Panics are always recovered, as if SetRecoverPanics was enabled, and passed to done as a
+// *PanicError, whose Index is -1 if the panic didn't happen in a function of the pipe, e.g. in
+// an output transform. A nil done is allowed, for executions whose results don't matter.
+func (p *Pipe) Go(done func([]interface{}, error), args ...interface{}) {
+	go func() {
+		out, err := p.executeRecovered(args)
+		if done != nil {
+			done(out, err)
+		}
+	}()
+}
+
+// executeRecovered executes the pipe with args, recovering any panic, see Go.
+func (p *Pipe) executeRecovered(args []interface{}) (out []interface{}, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			out, err = nil, &PanicError{Index: -1, Value: v, Stack: debug.Stack()}
+		}
+	}()
+	if err := p.lock(); err != nil {
+		return nil, err
+	}
+	defer p.mux.Unlock()
+	return p.execute(context.Background(), p.withDefaults(args), execOptions{recoverPanics: true})
+}
+
+// ExecuteToFixedPoint executes the pipe repeatedly, passing each run's outputs as the arguments
+// of the next run, until a run returns outputs deeply equal to its arguments (a fixed point),
+// which are then returned.
+//
+// An error is returned if no fixed point is reached after maxIter runs.
+func (p *Pipe) ExecuteToFixedPoint(maxIter int, args ...interface{}) ([]interface{}, error) {
+	if err := p.lock(); err != nil {
+		return nil, err
+	}
+	defer p.mux.Unlock()
+
+	args = p.withDefaults(args)
+	for i := 0; i < maxIter; i++ {
+		out, err := p.execute(context.Background(), args, execOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("iteration %d: %w", i, err)
+		}
+		if reflect.DeepEqual(out, args) {
+			return out, nil
+		}
+		args = out
+	}
+	return nil, fmt.Errorf("no fixed point reached after %d iterations", maxIter)
+}
+
+// ExecuteBatched groups items into batches of batchSize (the last one may be smaller) and
+// executes the pipe once per batch, passing the batch as the only argument. It returns the
+// outputs of every run, in order.
+//
+// If the first function expects a slice of a specific type (e.g. []int), each batch is
+// converted to that type, otherwise it is passed as a []interface{}.
+func (p *Pipe) ExecuteBatched(items []interface{}, batchSize int) ([][]interface{}, error) {
+	if batchSize <= 0 {
+		return nil, errors.New("batch size must be positive")
+	}
+
+	if err := p.lock(); err != nil {
+		return nil, err
+	}
+	defer p.mux.Unlock()
+
+	var sliceType reflect.Type
+	if len(p.funcs) > 0 && funcType(p.funcs[0].fn) != nil {
+		if params := p.paramTypes(funcType(p.funcs[0].fn)); len(params) > 0 && params[0].Kind() == reflect.Slice {
+			sliceType = params[0]
+		}
+	}
+
+	var results [][]interface{}
+	for start := 0; start < len(items); start += batchSize {
+		end := start + batchSize
+		if end > len(items) {
+			end = len(items)
+		}
+
+		var batch interface{} = items[start:end]
+		if sliceType != nil {
+			b, err := convertSlice(items[start:end], sliceType)
+			if err != nil {
+				return nil, fmt.Errorf("batch %d: %w", len(results), err)
+			}
+			batch = b
+		}
+
+		out, err := p.execute(context.Background(), []interface{}{batch}, execOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("batch %d: %w", len(results), err)
+		}
+		results = append(results, out)
+	}
+	return results, nil
+}
+
+// convertSlice converts items to a slice of the given type.
+func convertSlice(items []interface{}, sliceType reflect.Type) (interface{}, error) {
+	s := reflect.MakeSlice(sliceType, len(items), len(items))
+	for i, item := range items {
+		if item == nil {
+			continue
+		}
+		if !reflect.TypeOf(item).AssignableTo(sliceType.Elem()) {
+			return nil, fmt.Errorf("item %d (%T) is not assignable to %v", i, item, sliceType.Elem())
+		}
+		s.Index(i).Set(reflect.ValueOf(item))
+	}
+	return s.Interface(), nil
+}
+
+// ExecuteWhile behaves like Execute, but calls cont with the index and outputs of every
+// function after it runs. When cont returns false, the remaining functions are skipped
+// and the current outputs are returned with a nil error.
+func (p *Pipe) ExecuteWhile(cont func(index int, outputs []interface{}) bool, args ...interface{}) ([]interface{}, error) {
+	if err := p.lock(); err != nil {
+		return nil, err
+	}
+	defer p.mux.Unlock()
+	return p.execute(context.Background(), p.withDefaults(args), execOptions{cont: cont})
+}
+
+// ExecuteProfiled behaves like Execute, but also returns the time spent in each function that
+// ran: profile[i] is the duration of function i. On error, the profile ends with the failing function.
+func (p *Pipe) ExecuteProfiled(args ...interface{}) (outputs []interface{}, profile []time.Duration, err error) {
+	if err := p.lock(); err != nil {
+		return nil, nil, err
+	}
+	defer p.mux.Unlock()
+
+	outputs, err = p.execute(context.Background(), p.withDefaults(args), execOptions{
+		observe: func(_ int, _, _ []interface{}, d time.Duration, _ error) {
+			profile = append(profile, d)
+		},
+	})
+	return outputs, profile, err
+}
+
+// ExecuteAll behaves like Execute, but returns the outputs of every function rather than only
+// the last one's: perStage[i] holds the outputs of function i. On error, perStage holds the
+// outputs of the functions that succeeded before the failing one.
+func (p *Pipe) ExecuteAll(args ...interface{}) (perStage [][]interface{}, err error) {
+	if err := p.lock(); err != nil {
+		return nil, err
+	}
+	defer p.mux.Unlock()
+
+	_, err = p.execute(context.Background(), p.withDefaults(args), execOptions{
+		observe: func(_ int, _, outputs []interface{}, _ time.Duration, err error) {
+			if err == nil {
+				perStage = append(perStage, outputs)
+			}
+		},
+	})
+	return perStage, err
+}
+
+// Recording is the record of an execution, see RecordExecution.
+type Recording struct {
+	// Args are deep copies of the arguments of the execution (see CopyInputs), so that changes
+	// made to them by the functions don't affect the replays.
+	Args []interface{}
+	// Stages are the records of the functions that ran, in order, up to the failing one if any.
+	Stages []RecordedStage
+}
+
+// RecordedStage is the record of a function run by a recorded execution.
+type RecordedStage struct {
+	// Index is the position of the function in the pipe.
+	Index int
+	// Inputs and Outputs are the values the function got and returned, the latter in part at
+	// most if it failed.
+	Inputs, Outputs []interface{}
+	// Err is the error of the function, if it failed.
+	Err error
+}
+
+// RecordExecution behaves like Execute, but also returns a recording of the execution, with its
+// arguments and the inputs and outputs of every function that ran, even if it fails, e.g. to
+// inspect a failing execution or replay it later (see Recording.Replay).
+func (p *Pipe) RecordExecution(args ...interface{}) (*Recording, []interface{}, error) {
+	if err := p.lock(); err != nil {
+		return nil, nil, err
+	}
+	defer p.mux.Unlock()
+
+	args = p.withDefaults(args)
+	rec := &Recording{Args: deepCopyValues(args)}
+	outputs, err := p.execute(context.Background(), args, execOptions{
+		observe: func(index int, inputs, outputs []interface{}, _ time.Duration, err error) {
+			rec.Stages = append(rec.Stages, RecordedStage{Index: index, Inputs: copySlice(inputs), Outputs: copySlice(outputs), Err: err})
+		},
+	})
+	return rec, outputs, err
+}
+
+// Replay executes p with the recorded arguments, e.g. the pipe whose execution was recorded, to
+// reproduce it: deterministic functions return the same results, which a fixed version of the
+// pipe may not.
+func (r *Recording) Replay(p *Pipe) ([]interface{}, error) {
+	return p.Execute(deepCopyValues(r.Args)...)
+}
+
+// ExecuteBudgeted behaves like ExecuteContext, but splits the time left before the deadline of
+// ctx evenly between the functions that remain to run, each function getting its share when it
+// starts (so time left unused by a function is given to the next ones). A function that doesn't
+// return within its share makes ExecuteBudgeted fail with an error wrapping ErrBudgetExceeded.
+// Without a deadline, it is equivalent to ExecuteContext.
+//
+// To be able to give up on them, functions run in separate goroutines, which keep running
+// after exceeding their budget: functions should honor a context received as argument to
+// avoid leaking goroutines.
+func (p *Pipe) ExecuteBudgeted(ctx context.Context, args ...interface{}) ([]interface{}, error) {
+	if err := p.lock(); err != nil {
+		return nil, err
+	}
+	defer p.mux.Unlock()
+	return p.execute(ctx, p.withDefaults(args), execOptions{budgeted: true})
+}
+
+// execOptions alters how execute runs the functions of the pipe.
+type execOptions struct {
+	// cont, if set, is called after each function and stops the execution when it returns false.
+	cont func(index int, outputs []interface{}) bool
+	// recoverPanics forces panic recovery, regardless of SetRecoverPanics.
+	recoverPanics bool
+	// budgeted gives each function a share of the time left before the context's deadline.
+	budgeted bool
+	// observe, if set, is called after each function runs, even if it fails.
+	observe func(index int, inputs, outputs []interface{}, d time.Duration, err error)
+	// only, if set, selects the functions to run: the others are skipped, the outputs of a
+	// function being passed to the next selected one.
+	only func(index int) bool
+	// partial skips the output transform, for executions of a part of the pipe only.
+	partial bool
+	// salvage returns the inputs of the failing function alongside its error, see ExecutePartial.
+	salvage bool
+	// pool, if set, holds the slices of arguments passed to the functions.
+	pool *sync.Pool
+}
+
+// lock locks the pipe for an execution, unless the current goroutine is already executing it,
+// in which case ErrReentrant is returned.
+func (p *Pipe) lock() error {
+	if !p.running.empty() && p.running.has(goroutineID()) {
+		return ErrReentrant
+	}
+	p.mux.Lock()
+	return nil
+}
+
+// goroutineSet is a set of goroutines, by id. Its zero value is empty and ready to use.
+type goroutineSet struct {
+	mux sync.Mutex
+	ids map[uint64]int
+}
+
+func (s *goroutineSet) add(id uint64) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.ids == nil {
+		s.ids = make(map[uint64]int)
+	}
+	s.ids[id]++
+}
+
+func (s *goroutineSet) remove(id uint64) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.ids[id]--; s.ids[id] <= 0 {
+		delete(s.ids, id)
+	}
+}
+
+func (s *goroutineSet) empty() bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return len(s.ids) == 0
+}
+
+func (s *goroutineSet) has(id uint64) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.ids[id] > 0
+}
+
+// goroutineID returns the id of the current goroutine, parsed from its stack trace, which
+// starts with "goroutine <id> [".
+func goroutineID() uint64 {
+	var buf [64]byte
+	b := bytes.TrimPrefix(buf[:runtime.Stack(buf[:], false)], []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i > 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseUint(string(b), 10, 64)
+	return id
+}
+
+// failAll returns n results failing with err.
+func failAll(n int, err error) []Result {
+	results := make([]Result, n)
+	for i := range results {
+		results[i].Err = err
+	}
+	return results
+}
+
+// withDefaults returns args, or the default arguments if there are none (see SetDefaultArgs),
+// p.mux must be held. It is called once per execution, by the methods given the arguments.
+func (p *Pipe) withDefaults(args []interface{}) []interface{} {
+	if len(args) == 0 {
+		return copySlice(p.defaultArgs)
+	}
+	return args
+}
+
+// execute runs the functions of the pipe, p.mux must be held.
+func (p *Pipe) execute(ctx context.Context, args []interface{}, opts execOptions) (_ []interface{}, err error) {
+	if p.detectReentrancy {
+		id := goroutineID()
+		p.running.add(id)
+		defer p.running.remove(id)
+	}
+
+	var inputs []interface{} = args
+	if p.copyInputs {
+		inputs = deepCopyValues(args)
+	}
+
+	p.events.publish(ExecuteStart, -1, nil)
+	defer func() {
+		p.events.publishEnd(err)
+	}()
+
+	var ran int // number of functions run
+	if hook := p.timingHook; hook != nil {
+		start := time.Now()
+		defer func() {
+			hook(time.Since(start), ran, err)
+		}()
+	}
+
+	if len(p.funcs) == 0 && p.requireStages {
+		return nil, ErrNoStages
+	}
+
+	r := &run{
+		ctx:           ctx,
+		opts:          opts,
+		pool:          opts.pool,
+		recoverPanics: p.recoverPanics || opts.recoverPanics,
+		panicHandler:  p.panicHandler,
+		forwardErrors: p.forwardErrors,
+		ctxValues:     p.ctxValues,
+		valueBuilder:  p.valueBuilder,
+
+		detectReentrancy: p.detectReentrancy,
+		tracer:           p.tracer,
+		typeMatcher:      p.typeMatcher,
+		sliceThreading:   p.sliceThreading,
+	}
+	if p.typeBus {
+		r.bus = &typeBus{}
+		r.bus.add(inputs)
+	}
+
+	var records []stageRecord
+	if p.retainLast {
+		defer func() {
+			p.lastMux.Lock()
+			defer p.lastMux.Unlock()
+			p.last = records
+		}()
+	}
+
+	for i, s := range p.funcs {
+		if opts.only != nil && !opts.only(i) {
+			if p.retainLast {
+				records = append(records, stageRecord{skipped: true})
+			}
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			err = fmt.Errorf("function %d not executed: %w", i, err)
+			if opts.salvage {
+				return inputs, err
+			}
+			return nil, err
+		}
+
+		if p.retainLast {
+			records = append(records, stageRecord{inputs: copySlice(inputs)})
+		}
+
+		var hashes []uint64
+		if p.detectMutation {
+			hashes = hashValues(inputs)
+		}
+
+		p.events.publish(StageStart, i, nil)
+		ran++
+		start := time.Now()
+		var outputs []interface{}
+		var err error
+		if deadline, ok := ctx.Deadline(); ok && opts.budgeted {
+			outputs, err = p.runStageBudgeted(r, i, s, inputs, time.Until(deadline)/time.Duration(len(p.funcs)-i))
+		} else {
+			outputs, err = p.runStage(r, i, s, inputs)
+		}
+		// A closed guard stops the execution, but doesn't fail.
+		closed := err == errGuardClosed
+		if closed {
+			err = nil
+		}
+		if err == nil {
+			outputs, err = p.checkOutputs(i, outputs)
+		}
+		p.events.publish(StageEnd, i, err)
+		if opts.observe != nil {
+			opts.observe(i, inputs, outputs, time.Since(start), err)
+		}
+		if p.audit != nil {
+			if err := p.audit.write(i, s.fn, inputs, outputs, err); err != nil {
+				p.logf("pipe: writing the audit record of function %d: %v", i, err)
+			}
+		}
+		if p.retainLast {
+			records[len(records)-1].outputs = copySlice(outputs)
+		}
+		if hashes != nil {
+			for j, h := range hashValues(inputs) {
+				if h != hashes[j] {
+					p.logf("pipe: function %d mutated its argument %d (%T)", i, j, inputs[j])
+				}
+			}
+		}
+		if closed {
+			return p.transformOutputs(outputs, opts)
+		}
+		if err != nil {
+			if _, guard := s.fn.(*guardStage); guard || opts.salvage || errors.Is(err, ErrStop) {
+				return inputs, err
+			}
+			return nil, err
+		}
+
+		if p.dynamicStages {
+			dynamic := r.dynamic
+			var dynInputs []interface{}
+			dynInputs, outputs, err = p.runDynamic(r, i, outputs)
+			ran += r.dynamic - dynamic
+			if err == nil {
+				outputs, err = p.checkOutputs(i, outputs)
+			}
+			if err != nil {
+				if opts.salvage || errors.Is(err, ErrStop) {
+					return dynInputs, err
+				}
+				return nil, err
+			}
+		}
+
+		r.bus.add(outputs)
+
+		if opts.cont != nil && !opts.cont(i, outputs) {
+			return p.transformOutputs(outputs, opts)
+		}
+
+		// Set the inputs for the next function.
+		inputs = outputs
+	}
+
+	return p.transformOutputs(inputs, opts)
+}
+
+// checkOutputs returns an error wrapping ErrTooManyOutputs, instead of the outputs of the
+// function at the given index, if there are more than allowed by SetMaxOutputs.
+func (p *Pipe) checkOutputs(index int, outputs []interface{}) ([]interface{}, error) {
+	if p.maxOutputs > 0 && len(outputs) > p.maxOutputs {
+		return nil, fmt.Errorf("function %d returned %d outputs, more than %d: %w", index, len(outputs), p.maxOutputs, ErrTooManyOutputs)
+	}
+	return outputs, nil
+}
+
+// runDynamic runs the dynamic functions returned by the function at the given index, see
+// AllowDynamicStages. It returns the outputs of the last one (or outputs if there is none) and
+// its inputs.
+func (p *Pipe) runDynamic(r *run, index int, outputs []interface{}) (inputs, _ []interface{}, err error) {
+	max := p.maxDynamicStages
+	if max == 0 {
+		max = defaultMaxDynamicStages
+	}
+	for len(outputs) > 0 && isFunc(outputs[0]) {
+		if r.dynamic >= max {
+			return nil, nil, fmt.Errorf("function %d: more than %d dynamic functions", index, max)
+		}
+		r.dynamic++
+		inputs = outputs[1:]
+		if outputs, err = p.runFunc(r, index, outputs[0], inputs); err != nil {
+			return inputs, nil, err
+		}
+	}
+	return inputs, outputs, nil
+}
+
+// isFunc reports whether v is a non-nil function.
+func isFunc(v interface{}) bool {
+	fv := reflect.ValueOf(v)
+	return fv.Kind() == reflect.Func && !fv.IsNil()
+}
+
+// transformOutputs applies the output transform and return type, if any, to the final outputs
+// of an execution, unless it is partial.
+func (p *Pipe) transformOutputs(outputs []interface{}, opts execOptions) ([]interface{}, error) {
+	if opts.partial {
+		return outputs, nil
+	}
+	if p.outputTransform != nil {
+		out, err := p.outputTransform(outputs)
+		if err != nil {
+			return nil, fmt.Errorf("transforming outputs: %w", err)
+		}
+		outputs = out
+	}
+	if p.returnType != nil {
+		return convertOutput(outputs, p.returnType)
+	}
+	return outputs, nil
+}
+
+// convertOutput converts the single value of outputs to the type t.
+func convertOutput(outputs []interface{}, t reflect.Type) ([]interface{}, error) {
+	if len(outputs) != 1 {
+		return nil, fmt.Errorf("got %d outputs, expected a single %v", len(outputs), t)
+	}
+	if outputs[0] == nil {
+		if !isNillable(t) {
+			return nil, fmt.Errorf("nil output can't be converted to %v", t)
+		}
+		return []interface{}{reflect.Zero(t).Interface()}, nil
+	}
+	v := reflect.ValueOf(outputs[0])
+	// Unlike ConvertibleTo, CanConvert checks the length of a slice converted to an array.
+	if !v.CanConvert(t) {
+		return nil, fmt.Errorf("output of type %v can't be converted to %v", v.Type(), t)
+	}
+	return []interface{}{v.Convert(t).Interface()}, nil
+}
+
+// runStageBudgeted runs a function like runStage, in a separate goroutine, and fails with
+// ErrBudgetExceeded if it doesn't return within budget. The goroutine is left running.
+// A panic that isn't recovered by runStage is raised again in the calling goroutine.
+func (p *Pipe) runStageBudgeted(r *run, index int, s stage, inputs []interface{}, budget time.Duration) ([]interface{}, error) {
+	done := make(chan Result, 1)
+	panicked := make(chan interface{}, 1)
+	go func() {
+		defer func() {
+			// Unrecovered panics are raised again by the caller, as they would be by Execute.
+			if v := recover(); v != nil {
+				panicked <- v
+			}
+		}()
+		if r.detectReentrancy {
+			id := goroutineID()
+			p.running.add(id)
+			defer p.running.remove(id)
+		}
+		outputs, err := p.runStage(r, index, s, inputs)
+		done <- Result{Outputs: outputs, Err: err}
+	}()
+
+	timer := time.NewTimer(budget)
+	defer timer.Stop()
+	select {
+	case res := <-done:
+		return res.Outputs, res.Err
+	case v := <-panicked:
+		panic(v)
+	case <-timer.C:
+		return nil, fmt.Errorf("function %d (%s) didn't return within %v: %w", index, funcName(s.fn), budget, ErrBudgetExceeded)
+	}
+}
+
+// hashValues returns a hash of the deep representation of each value.
+func hashValues(values []interface{}) []uint64 {
+	hashes := make([]uint64, len(values))
+	for i, v := range values {
+		h := fnv.New64a()
+		fmt.Fprintf(h, "%#v", v)
+		hashes[i] = h.Sum64()
+	}
+	return hashes
+}
+
+// run holds the state of a single execution.
+//
+// It also holds the settings of the pipe used while running a function, read when the execution
+// starts, so that a function left running by ExecuteBudgeted doesn't read them without the lock.
+type run struct {
+	ctx  context.Context
+	opts execOptions
+	bus  *typeBus
+
+	recoverPanics bool
+	panicHandler  func(index int, recovered interface{}) error
+	forwardErrors bool
+	ctxValues     map[reflect.Type]interface{}
+	valueBuilder  func(arg interface{}, wantType reflect.Type) (reflect.Value, error)
+
+	detectReentrancy bool
+	tracer           Tracer
+	typeMatcher      func(argType, paramType reflect.Type) bool
+	sliceThreading   bool
+
+	// dynamic is the number of dynamic functions run so far, see AllowDynamicStages.
+	dynamic int
+	// pool, if set, holds the slices of arguments, see ExecuteWithPool.
+	pool *sync.Pool
+}
+
+// borrowValues returns an empty slice of at least n capacity for the arguments of a function,
+// taken from the pool if any.
+func (r *run) borrowValues(n int) []reflect.Value {
+	if r.pool != nil {
+		if in, ok := r.pool.Get().(*[]reflect.Value); ok && cap(*in) >= n {
+			return (*in)[:0]
+		}
+	}
+	return make([]reflect.Value, 0, n)
+}
+
+// releaseValues puts the slice of arguments in back to the pool if any. in must not be used
+// afterwards.
+func (r *run) releaseValues(in []reflect.Value) {
+	if r.pool == nil {
+		return
+	}
+	clear(in) // don't retain the arguments
+	in = in[:0]
+	r.pool.Put(&in)
+}
+
+// runStage runs the stage at the given index with the inputs, applying its settings, and
+// returns its outputs. When the function runs but returns an error, its outputs are returned
+// alongside the error.
+func (p *Pipe) runStage(r *run, index int, s stage, inputs []interface{}) ([]interface{}, error) {
+	if s.spanName != "" && r.tracer != nil {
+		ctx, end := r.tracer.StartSpan(r.ctx, s.spanName)
+		defer end()
+		parent := r.ctx
+		r.ctx = ctx
+		defer func() { r.ctx = parent }()
+	}
+	if r.sliceThreading && funcType(s.fn) != nil {
+		inputs = []interface{}{copySlice(inputs)}
+		s.spread = true
+	}
+	outputs, err := p.runMemoized(r, index, s, inputs)
+	if err == nil && s.spread && len(outputs) == 1 {
+		if values, ok := outputs[0].([]interface{}); ok {
+			outputs = values
+		}
+	}
+	return outputs, err
+}
+
+// runMemoized runs the function of the stage, unless its outputs for the inputs are cached.
+func (p *Pipe) runMemoized(r *run, index int, s stage, inputs []interface{}) ([]interface{}, error) {
+	if s.memo == nil {
+		return p.runFunc(r, index, s.fn, inputs)
+	}
+
+	key := s.memo.keyFunc(inputs)
+	if outputs, ok := s.memo.get(key); ok {
+		return outputs, nil
+	}
+	outputs, err := p.runFunc(r, index, s.fn, inputs)
+	if err == nil {
+		s.memo.set(key, outputs)
+	}
+	return outputs, err
+}
+
+// runFunc runs the function or Stage at the given index with the inputs and returns its outputs.
+// When the function runs but returns an error, its outputs are returned alongside the error.
+func (p *Pipe) runFunc(r *run, index int, fn interface{}, inputs []interface{}) ([]interface{}, error) {
+	if m, ok := fn.(*multiplexStage); ok {
+		return p.runMultiplex(r, index, m, inputs)
+	}
+	if g, ok := fn.(*guardStage); ok {
+		return p.runGuard(r, index, g, inputs)
+	}
+	if s, ok := fn.(Stage); ok {
+		var outputs []interface{}
+		var runErr error
+		err := p.call(r, index, func() error {
+			if f, ok := s.(*fanInStage); ok {
+				outputs, runErr = p.runFanIn(r, f, copySlice(inputs))
+			} else {
+				outputs, runErr = s.Run(copySlice(inputs))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, &StageError{Index: index, Err: err}
+		}
+		if runErr != nil {
+			if r.forwardErrors && !errors.Is(runErr, ErrStop) {
+				return append(outputs, runErr), nil
+			}
+			return outputs, &StageError{Index: index, Err: runErr}
+		}
+		return outputs, nil
+	}
+
+	in, err := p.buildArgs(r, index, fn, inputs)
+	if err != nil {
+		return nil, err
+	}
+	defer r.releaseValues(in)
+
+	// Call the function with the determined arguments.
+	var out []reflect.Value
+	err = p.call(r, index, func() error {
+		out = callValue(reflect.ValueOf(fn), in)
+		return nil
+	})
+	if err != nil {
+		return nil, &StageError{Index: index, Err: err}
+	}
+
+	// Store the outputs. Unless errors are forwarded, nil errors are dropped so that the
+	// values returned alongside them line up with the next function's parameters.
+	var outputs []interface{}
+	for _, o := range out {
+		if o.Type() == errorType && o.IsNil() && !r.forwardErrors {
+			continue
+		}
+		outputs = append(outputs, o.Interface())
+	}
+	if err := firstError(out); err != nil && (!r.forwardErrors || errors.Is(err, ErrStop)) {
+		return outputs, &StageError{Index: index, Err: err}
+	}
+	return outputs, nil
+}
+
+// Iterate returns a sequence that runs the pipe once per item, passing the item as the only
+// argument to the first function, and yields each run's outputs and error.
+//
+// Items are executed lazily, one per iteration: when the body of the range loop returns or
+// breaks, iteration stops and the remaining items are never executed. An error doesn't stop
+// the iteration by itself.
+func (p *Pipe) Iterate(items []interface{}) iter.Seq2[[]interface{}, error] {
+	return func(yield func([]interface{}, error) bool) {
+		for _, item := range items {
+			if !yield(p.Execute(item)) {
+				return
+			}
+		}
+	}
+}
+
+// buildArgs determines the arguments to call the function at the given index with.
+//
+// A leading StageInfo parameter and parameters bound to context values are filled
+// automatically, the remaining parameters are taken in order from the inputs, or from the
+// bus when an input is missing or doesn't match. A nil input is passed as the zero value of
+// a pointer, interface, map, slice, func or chan parameter.
+func (p *Pipe) buildArgs(r *run, index int, fn interface{}, inputs []interface{}) ([]reflect.Value, error) {
+	fnType := reflect.TypeOf(fn)
+	if len(inputs) < len(boundParamTypes(fnType, r.ctxValues)) && r.bus == nil {
+		return nil, fmt.Errorf("not enough arguments for function %v", fnType)
+	}
+
+	in := r.borrowValues(fnType.NumIn())
+	var k int // index of the next input to use
+	for j := 0; j < fnType.NumIn(); j++ {
+		t := fnType.In(j)
+		if j == 0 && t == stageInfoType {
+			in = append(in, reflect.ValueOf(StageInfo{Index: index, Name: funcName(fn)}))
+			continue
+		}
+		if key, ok := r.ctxValues[t]; ok {
+			v := r.ctx.Value(key)
+			if v == nil {
+				in = append(in, reflect.Zero(t))
+				continue
+			}
+			if !reflect.TypeOf(v).AssignableTo(t) {
+				return nil, &TypeMismatchError{Index: index, ParamIndex: j, Expected: t, Actual: reflect.TypeOf(v)}
+			}
+			in = append(in, reflect.ValueOf(v))
+			continue
+		}
+
+		if fnType.IsVariadic() && j == fnType.NumIn()-1 {
+			v, err := variadicArg(index, j, t, inputs[min(k, len(inputs)):])
+			if err != nil {
+				return nil, err
+			}
+			in = append(in, v)
+			continue
+		}
+
+		// Loop through the inputs to determine whether they match the expected types.
+		present := k < len(inputs)
+		var arg interface{}
+		if present {
+			arg = inputs[k]
+		}
+		k++
+
+		if l, ok := arg.(Lazy); ok && l != nil && t != lazyType {
+			err := p.call(r, index, func() error {
+				arg = l()
+				return nil
+			})
+			if err != nil {
+				return nil, &StageError{Index: index, Err: err}
+			}
+		}
+
+		if present && r.valueBuilder != nil {
+			v, err := r.valueBuilder(arg, t)
+			if err == nil && (!v.IsValid() || !v.Type().AssignableTo(t)) {
+				err = fmt.Errorf("built value is not a %v", t)
+			}
+			if err == nil {
+				in = append(in, v)
+				continue
+			}
+			if bv, ok := r.bus.lookup(t); ok {
+				in = append(in, reflect.ValueOf(bv))
+				continue
+			}
+			return nil, fmt.Errorf("function %d: argument %d: %w", index, j, err)
+		}
+
+		switch {
+		case present && arg == nil && isNillable(t):
+			in = append(in, reflect.Zero(t))
+		case arg != nil && reflect.TypeOf(arg).AssignableTo(t):
+			in = append(in, reflect.ValueOf(arg))
+		case arg != nil && r.typeMatcher != nil && r.typeMatcher(reflect.TypeOf(arg), t):
+			v := reflect.ValueOf(arg)
+			if v.Type().ConvertibleTo(t) {
+				v = v.Convert(t)
+			}
+			in = append(in, v)
+		default:
+			v, ok := r.bus.lookup(t)
+			if !ok {
+				if !present {
+					return nil, fmt.Errorf("not enough arguments for function %v", fnType)
+				}
+				return nil, &TypeMismatchError{Index: index, ParamIndex: j, Expected: t, Actual: reflect.TypeOf(arg)}
+			}
+			in = append(in, reflect.ValueOf(v))
+		}
+	}
+	return in, nil
+}
+
+// variadicArg returns the slice passed to the variadic parameter j, of type t, of the function at
+// the given index: the first of the remaining inputs if it is already a slice of type t,
+// otherwise the remaining inputs until the first that doesn't match the element type.
+func variadicArg(index, j int, t reflect.Type, inputs []interface{}) (reflect.Value, error) {
+	if len(inputs) > 0 && inputs[0] != nil && reflect.TypeOf(inputs[0]).AssignableTo(t) {
+		return reflect.ValueOf(inputs[0]), nil
+	}
+	elem := t.Elem()
+	values := reflect.MakeSlice(t, 0, len(inputs))
+	for _, arg := range inputs {
+		switch {
+		case arg == nil && isNillable(elem):
+			values = reflect.Append(values, reflect.Zero(elem))
+		case arg != nil && reflect.TypeOf(arg).AssignableTo(elem):
+			values = reflect.Append(values, reflect.ValueOf(arg))
+		default:
+			if values.Len() == 0 {
+				return reflect.Value{}, &TypeMismatchError{Index: index, ParamIndex: j, Expected: elem, Actual: reflect.TypeOf(arg)}
+			}
+			return values, nil
+		}
+	}
+	return values, nil
+}
+
+// isNillable reports whether nil is a valid value of type t.
+func isNillable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		return true
+	}
+	return false
+}
+
+// typeBus holds the latest value of each type seen during an execution. A nil *typeBus is
+// empty and ignores additions.
+type typeBus struct {
+	values []interface{}
+}
+
+// add records the non-nil values, replacing the previous values of the same types.
+func (b *typeBus) add(values []interface{}) {
+	if b == nil {
+		return
+	}
+	for _, v := range values {
+		if v == nil {
+			continue
+		}
+		for i, old := range b.values {
+			if reflect.TypeOf(old) == reflect.TypeOf(v) {
+				b.values = append(b.values[:i], b.values[i+1:]...)
+				break
+			}
+		}
+		b.values = append(b.values, v)
+	}
+}
+
+// lookup returns the most recently added value assignable to t.
+func (b *typeBus) lookup(t reflect.Type) (interface{}, bool) {
+	if b == nil {
+		return nil, false
+	}
+	for i := len(b.values) - 1; i >= 0; i-- {
+		if reflect.TypeOf(b.values[i]).AssignableTo(t) {
+			return b.values[i], true
+		}
+	}
+	return nil, false
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// firstError returns the first non-nil error among the values returned by a function.
+func firstError(out []reflect.Value) error {
+	for _, o := range out {
+		if o.IsValid() && o.Type() == errorType && !o.IsNil() {
+			return o.Interface().(error)
+		}
+	}
+	return nil
+}
+
+// funcType returns the type of fn, or nil if fn is a Stage, whose signature is only known at runtime.
+func funcType(fn interface{}) reflect.Type {
+	if _, ok := fn.(Stage); ok {
+		return nil
+	}
+	return reflect.TypeOf(fn)
+}
+
+// sameFunc reports whether a and b are the same function, comparing functions by their code
+// pointer (except the ones made by reflect.MakeFunc) and Stages by equality.
+func sameFunc(a, b interface{}) bool {
+	aType, bType := funcType(a), funcType(b)
+	if aType == nil || bType == nil {
+		return aType == nil && bType == nil && reflect.TypeOf(a) == reflect.TypeOf(b) &&
+			reflect.TypeOf(a).Comparable() && a == b
+	}
+	pc := reflect.ValueOf(a).Pointer()
+	return pc != makeFuncPC && pc == reflect.ValueOf(b).Pointer()
+}
+
+// makeFuncPC is the code pointer shared by all the functions made by reflect.MakeFunc,
+// e.g. the wrappers of AddWithFallback, which therefore can't be told apart by it.
+var makeFuncPC = reflect.MakeFunc(reflect.TypeOf(func() {}), func([]reflect.Value) []reflect.Value {
+	return nil
+}).Pointer()
+
+// funcName returns the name of the function fn, as reported by the runtime, or the type
+// name of a Stage.
+func funcName(fn interface{}) string {
+	if funcType(fn) == nil {
+		return reflect.TypeOf(fn).String()
+	}
+	if f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()); f != nil {
+		return f.Name()
+	}
+	return ""
+}
+
+// call calls f, converting a panic into an error if recovery is enabled for the run r.
+// index is the position of the function being run by f.
+func (p *Pipe) call(r *run, index int, f func() error) (err error) {
+	if r.recoverPanics {
+		defer func() {
+			if v := recover(); v != nil {
+				err = nil
+				if r.panicHandler != nil {
+					err = r.panicHandler(index, v)
+				}
+				if err == nil {
+					err = &PanicError{Index: index, Value: v, Stack: debug.Stack()}
+				}
+			}
+		}()
+	}
+	return f()
+}
+
+// SetRecoverPanics enables or disables recovering from panics inside the functions of the pipe.
+// When enabled, a panicking function makes Execute return an error instead of crashing.
+func (p *Pipe) SetRecoverPanics(recover bool) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.recoverPanics = recover
+}
+
+// SetPanicHandler sets the function used to convert a recovered panic into the error returned
+// from Execute. index is the position of the panicking function in the pipe.
+//
+// The handler is only used when panic recovery is enabled (see SetRecoverPanics). If it is nil
+// or returns nil, a *PanicError is returned instead.
+func (p *Pipe) SetPanicHandler(h func(index int, recovered interface{}) error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.panicHandler = h
+}
+
+// SetErrorStops sets whether a non-nil error returned by a function stops the execution,
+// which is the default.
+//
+// When disabled, returned errors other than ErrStop are forwarded to the next function as
+// ordinary outputs, in the position they were returned at (a Stage's error is appended to its
+// outputs), and Execute only fails on arguments mismatches and recovered panics.
+func (p *Pipe) SetErrorStops(stops bool) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.forwardErrors = !stops
+}
+
+// EnableTypeBus enables or disables satisfying parameters from earlier outputs.
+//
+// When enabled, the latest value of each type passed to Execute or returned by a function is
+// kept for the rest of the execution, and a parameter that can't be satisfied by the previous
+// function's outputs (because they are too few or don't match) is filled with the latest value
+// assignable to its type: the latest value of each type wins.
+func (p *Pipe) EnableTypeBus(enable bool) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.typeBus = enable
+}
+
+// BindContextValue makes every parameter of type paramType be filled with the value stored in
+// the context under ctxKey, rather than with the previous function's outputs. The context is the
+// one passed to ExecuteContext, Execute uses an empty context.
+//
+// A missing value is passed as the zero value of paramType. paramType should be specific
+// enough (e.g. a dedicated type) not to capture parameters meant to receive outputs.
+func (p *Pipe) BindContextValue(paramType reflect.Type, ctxKey interface{}) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	// The map is replaced rather than updated, as runs in progress may still be reading it.
+	ctxValues := make(map[reflect.Type]interface{}, len(p.ctxValues)+1)
+	for t, key := range p.ctxValues {
+		ctxValues[t] = key
+	}
+	ctxValues[paramType] = ctxKey
+	p.ctxValues = ctxValues
+}
+
+// SetValueBuilder sets the function used to convert an input into the argument passed to a
+// parameter of type wantType, e.g. to adapt values to types they aren't assignable to.
+// The returned value must be assignable to wantType, otherwise Execute fails.
+//
+// By default, a nil input is converted to the zero value of nillable types and other inputs
+// are used as is if they are assignable to wantType. A nil builder restores the default.
+func (p *Pipe) SetValueBuilder(b func(arg interface{}, wantType reflect.Type) (reflect.Value, error)) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.valueBuilder = b
+}
+
+// SetDefaultArgs sets the arguments passed to the first function by the executions given none,
+// e.g. a call of Execute without arguments, while explicit arguments replace them all. The
+// executions that run the pipe repeatedly, e.g. ExecuteUntil, only use them for the first run.
+// Calling SetDefaultArgs without arguments removes the defaults.
+func (p *Pipe) SetDefaultArgs(args ...interface{}) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.defaultArgs = copySlice(args)
+}
+
+// UseSliceThreading enables or disables passing the outputs of a function to the next one as a
+// single []interface{} argument, rather than one argument per output, so that functions of the
+// shape func([]interface{}) []interface{} can take and return any number of values. A single
+// []interface{} output is spread, as for the functions added with AddSpread, so that the
+// values it holds are the ones passed on. Stages always take the values as a slice anyway.
+//
+// In this mode, every function must take a []interface{} as its only argument, and args passed
+// to Check must be wrapped in a slice too.
+func (p *Pipe) UseSliceThreading(use bool) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.sliceThreading = use
+}
+
+// SetMaxOutputs sets the maximum number of outputs of a function, e.g. of a function added with
+// AddSpread processing untrusted data: an execution fails with an error wrapping
+// ErrTooManyOutputs, rather than passing them on, when a function returns more. A non-positive n
+// removes the limit, which is the default.
+func (p *Pipe) SetMaxOutputs(n int) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if n < 0 {
+		n = 0
+	}
+	p.maxOutputs = n
+}
+
+// SetTypeMatcher sets a function deciding whether an input of type argType, that isn't
+// assignable to it, can be passed to a parameter of type paramType, e.g. to allow ints for
+// float64 parameters. The matched input is converted to paramType if possible (see
+// reflect.Value.Convert), and passed as is otherwise. A nil matcher restores the default, only
+// allowing assignable inputs.
+//
+// The matcher must only allow types the input can be converted to: otherwise the input is
+// passed as is, and calling the function panics, which fails the execution only if panics are
+// recovered (see SetRecoverPanics). The matcher is only used by executions: Validate and Check
+// still require assignable types.
+func (p *Pipe) SetTypeMatcher(m func(argType, paramType reflect.Type) bool) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.typeMatcher = m
+}
+
+// SetOutputTransform sets a function applied to the final outputs of every successful
+// execution, whose result is returned instead. An error returned by the transform is
+// returned from Execute. A nil transform disables it.
+func (p *Pipe) SetOutputTransform(t func([]interface{}) ([]interface{}, error)) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.outputTransform = t
+}
+
+// AllowDynamicStages enables or disables dynamic functions: when enabled, a non-nil function
+// returned as the first output of a function is run right after it, with the remaining outputs
+// as arguments, before the next function of the pipe, which then gets the outputs of the
+// dynamic function. A dynamic function can itself return a dynamic function.
+//
+// Dynamic functions are not added to the pipe, they only run during the execution that
+// returned them, and have the index of the function that returned them. To guard against
+// unbounded growth, an execution fails once it runs more dynamic functions than the limit set
+// by SetMaxDynamicStages.
+func (p *Pipe) AllowDynamicStages(allow bool) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.dynamicStages = allow
+}
+
+// SetMaxDynamicStages sets the maximum number of dynamic functions run by an execution, see
+// AllowDynamicStages. A non-positive max restores the default of 100.
+func (p *Pipe) SetMaxDynamicStages(max int) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if max < 0 {
+		max = 0
+	}
+	p.maxDynamicStages = max
+}
+
+// RequireStages sets whether executing an empty pipe fails with ErrNoStages, which may reveal
+// a pipe that was never filled. By default, an empty pipe returns its arguments as outputs.
+func (p *Pipe) RequireStages(require bool) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.requireStages = require
+}
+
+// SetTimingHook sets a function called at the end of every execution of the pipe with its total
+// duration, the number of functions that ran (including a failing one) and the error returned,
+// if any. A nil hook disables it.
+//
+// The hook is called while the pipe is locked, so it must not use the pipe.
+func (p *Pipe) SetTimingHook(hook func(total time.Duration, stages int, err error)) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.timingHook = hook
+}
+
+// SetReturnType sets the type of the single output of every successful execution: the output,
+// after the output transform if any, is converted to t (see reflect.Value.Convert), and Execute
+// fails if there isn't exactly one output or it can't be converted. A nil t disables it.
+func (p *Pipe) SetReturnType(t reflect.Type) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.returnType = t
+}
+
+// DetectMutation enables or disables detecting functions that mutate their arguments, e.g. a
+// slice or map returned by the previous function. Mutations are reported as warnings through
+// the logger.
+//
+// Detection compares a hash of the representation of each argument before and after the
+// function runs, so it is slow and only meant for debugging.
+func (p *Pipe) DetectMutation(detect bool) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.detectMutation = detect
+}
+
+// DetectReentrancy enables or disables detecting reentrant executions, i.e. a function
+// executing the pipe it belongs to (directly or through other pipes), which would deadlock:
+// when enabled, such an execution fails with ErrReentrant instead.
+//
+// Detection tracks the goroutines executing the pipe, which is slow since Go doesn't expose
+// goroutine ids: it is mostly meant for debugging deadlocks.
+func (p *Pipe) DetectReentrancy(detect bool) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.detectReentrancy = detect
+}
+
+// SetRetainLast enables or disables retaining the inputs and outputs of every stage run
+// by the most recent Execute, which can then be inspected with LastStage.
+func (p *Pipe) SetRetainLast(retain bool) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.retainLast = retain
+	if !retain {
+		p.last = nil
+	}
+}
+
+// LastStage returns copies of the inputs and outputs of the stage at the given index as seen
+// during the most recent Execute. ok is false if retention is disabled or the stage didn't run.
+//
+// If the stage failed before being called (e.g. because of mismatched arguments), outputs is nil.
+func (p *Pipe) LastStage(index int) (inputs, outputs []interface{}, ok bool) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if index < 0 || index >= len(p.last) || p.last[index].skipped {
+		return nil, nil, false
+	}
+	r := p.last[index]
+	return copySlice(r.inputs), copySlice(r.outputs), true
+}
+
+// copySlice returns a shallow copy of s, preserving nil.
+func copySlice(s []interface{}) []interface{} {
+	if s == nil {
+		return nil
+	}
+	return append([]interface{}{}, s...)
 }
@@ -1,9 +1,18 @@
 package pipe
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
+	"io"
+	"math"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestPipe_Execute(t *testing.T) {
@@ -76,3 +85,2782 @@ func TestPipe_Execute(t *testing.T) {
 		}
 	}
 }
+
+func TestPipe_LastStage(t *testing.T) {
+	p, err := New(
+		func(a, b int) (int, int) { return a * 2, b },
+		func(a, b int) (int, error) {
+			if b == 0 {
+				return 0, errors.New("division by zero")
+			}
+			return a / b, nil
+		},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	p.SetRetainLast(true)
+
+	if _, err := p.Execute(5, 0); err == nil {
+		t.Fatal("expected an error but got nil")
+	}
+
+	inputs, outputs, ok := p.LastStage(1)
+	if !ok {
+		t.Fatal("expected stage 1 to be retained")
+	}
+	if !reflect.DeepEqual(inputs, []interface{}{10, 0}) {
+		t.Errorf("inputs mismatch: expected %v, got %v", []interface{}{10, 0}, inputs)
+	}
+	if len(outputs) != 2 || outputs[1] == nil {
+		t.Errorf("expected outputs to contain the returned error, got %v", outputs)
+	}
+
+	if _, _, ok := p.LastStage(2); ok {
+		t.Error("expected no record for a stage that doesn't exist")
+	}
+}
+
+func TestPipe_SetPanicHandler(t *testing.T) {
+	errBoom := errors.New("boom")
+
+	p, err := New(
+		func(a int) int { return a + 1 },
+		func(a int) int {
+			if a > 10 {
+				panic("too big")
+			}
+			return a
+		},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	p.SetRecoverPanics(true)
+
+	// Without a handler, the default *PanicError is returned.
+	_, err = p.Execute(20)
+	var pe *PanicError
+	if !errors.As(err, &pe) {
+		t.Fatalf("expected a *PanicError, got %v", err)
+	}
+	if pe.Index != 1 || pe.Value != "too big" {
+		t.Errorf("unexpected panic error fields: %+v", pe)
+	}
+
+	p.SetPanicHandler(func(index int, recovered interface{}) error {
+		if recovered == "too big" {
+			return errBoom
+		}
+		return nil
+	})
+	if _, err := p.Execute(20); !errors.Is(err, errBoom) {
+		t.Errorf("expected %v, got %v", errBoom, err)
+	}
+	if out, err := p.Execute(1); err != nil || !reflect.DeepEqual(out, []interface{}{2}) {
+		t.Errorf("unexpected result: %v, %v", out, err)
+	}
+}
+
+func TestPipe_Iterate(t *testing.T) {
+	var calls int
+	p, err := New(func(a int) int {
+		calls++
+		return a * a
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	var results []interface{}
+	for out, err := range p.Iterate([]interface{}{1, 2, 3, 4}) {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		results = append(results, out[0])
+		if len(results) == 2 {
+			break
+		}
+	}
+
+	if !reflect.DeepEqual(results, []interface{}{1, 4}) {
+		t.Errorf("results mismatch: expected %v, got %v", []interface{}{1, 4}, results)
+	}
+	if calls != 2 {
+		t.Errorf("expected the pipe to run 2 times, ran %d times", calls)
+	}
+}
+
+func TestPipe_StageInfo(t *testing.T) {
+	p, err := New(
+		func(a int) string { return strconv.Itoa(a) },
+		func(info StageInfo, s string) string {
+			return fmt.Sprintf("stage %d: %s", info.Index, s)
+		},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	out, err := p.Execute(42)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{"stage 1: 42"}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{"stage 1: 42"}, out)
+	}
+}
+
+type testLogger struct {
+	lines []string
+}
+
+func (l *testLogger) Printf(format string, v ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func TestPipe_InterfaceToConcrete(t *testing.T) {
+	p, err := New(
+		func(b []byte) io.Reader { return bytes.NewReader(b) },
+		func(r *bytes.Reader) int { return r.Len() },
+	)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	logger := &testLogger{}
+	p.SetLogger(logger)
+
+	if err := p.Validate(); err != nil {
+		t.Errorf("unexpected validation error: %v", err)
+	}
+	if len(logger.lines) != 1 {
+		t.Errorf("expected 1 warning, got %v", logger.lines)
+	}
+
+	out, err := p.Execute([]byte("hello"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{5}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{5}, out)
+	}
+}
+
+func TestPipe_Validate(t *testing.T) {
+	p, err := New(
+		func(a int) string { return strconv.Itoa(a) },
+		func(a int) int { return a },
+	)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	err = p.Validate()
+	if err == nil {
+		t.Fatal("expected a validation error but got nil")
+	}
+	for _, signature := range []string{"function 0 returns 1 values (string)", "function 1 takes 1 arguments (int)"} {
+		if !strings.Contains(err.Error(), signature) {
+			t.Errorf("expected the error to contain %q, got %q", signature, err)
+		}
+	}
+
+	p, _ = New(strconv.Itoa, strings.Repeat)
+	err = p.Validate()
+	if err == nil || !strings.Contains(err.Error(), "takes 2 arguments (string, int)") {
+		t.Errorf("expected an arity error listing both signatures, got %v", err)
+	}
+}
+
+func TestPipe_ExecuteTimeout(t *testing.T) {
+	var called bool
+	p, err := New(
+		func(a int) int {
+			time.Sleep(50 * time.Millisecond)
+			return a
+		},
+		func(a int) int {
+			called = true
+			return a
+		},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	_, err = p.ExecuteTimeout(context.Background(), 10*time.Millisecond, 1)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected an error wrapping %v, got %v", context.DeadlineExceeded, err)
+	}
+	if called {
+		t.Error("expected the second function not to be called")
+	}
+
+	out, err := p.ExecuteTimeout(context.Background(), time.Second, 1)
+	if err != nil || !reflect.DeepEqual(out, []interface{}{1}) {
+		t.Errorf("unexpected result: %v, %v", out, err)
+	}
+}
+
+func TestPipe_ExecuteBatched(t *testing.T) {
+	var runs int
+	p, err := New(func(batch []int) int {
+		runs++
+		var sum int
+		for _, n := range batch {
+			sum += n
+		}
+		return sum
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	results, err := p.ExecuteBatched([]interface{}{1, 2, 3, 4, 5, 6, 7}, 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := [][]interface{}{{6}, {15}, {7}}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("results mismatch: expected %v, got %v", expected, results)
+	}
+	if runs != 3 {
+		t.Errorf("expected 3 runs, got %d", runs)
+	}
+
+	if _, err := p.ExecuteBatched([]interface{}{1}, 0); err == nil {
+		t.Error("expected an error for a zero batch size")
+	}
+}
+
+func TestPipe_AddWithFallback(t *testing.T) {
+	p, err := New(func(s string) string { return s })
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	err = p.AddWithFallback(
+		func(s string) (int, error) { return 0, errors.New("primary failed") },
+		func(s string) (int, error) { return len(s), nil },
+	)
+	if err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+	if err := p.Add(func(n int) int { return n * 2 }); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+
+	out, err := p.Execute("abc")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{6}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{6}, out)
+	}
+
+	err = p.AddWithFallback(func(s string) int { return 0 }, func(n int) int { return n })
+	if err == nil {
+		t.Error("expected an error for an incompatible fallback")
+	}
+	if err := p.AddWithFallback(nil, func(n int) int { return n }); err == nil {
+		t.Error("expected an error for a nil primary")
+	}
+
+	p, _ = New()
+	err = p.AddWithFallback(
+		func(a ...int) (int, error) { return 0, errors.New("primary failed") },
+		func(a ...int) (int, error) { return len(a), nil },
+	)
+	if err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+	out, err = p.Execute([]int{1, 2, 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{3}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{3}, out)
+	}
+}
+
+func TestPipe_DOT(t *testing.T) {
+	p, err := New(
+		func(a int) (string, error) { return strconv.Itoa(a), nil },
+		func(s string) []byte { return []byte(s) },
+		func(b []byte) int { return len(b) },
+	)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	dot := p.DOT()
+	if n := strings.Count(dot, "[label="); n != 5 {
+		t.Errorf("expected 3 nodes and 2 edges, got %d labels in:\n%s", n, dot)
+	}
+	if n := strings.Count(dot, "->"); n != 2 {
+		t.Errorf("expected 2 edges, got %d in:\n%s", n, dot)
+	}
+	if !strings.Contains(dot, `n0 -> n1 [label="string"]`) {
+		t.Errorf("expected the first edge to be labeled with the flowing types, got:\n%s", dot)
+	}
+
+	p.SetErrorStops(false)
+	if dot := p.DOT(); !strings.Contains(dot, `n0 -> n1 [label="string, error"]`) {
+		t.Errorf("expected the forwarded error in the first edge's label, got:\n%s", dot)
+	}
+}
+
+func double(a int) int { return a * 2 }
+
+func increment(a int) int { return a + 1 }
+
+func TestPipe_Dedup(t *testing.T) {
+	p, err := New(double, double, increment, double)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	p.Dedup()
+	if len(p.funcs) != 3 {
+		t.Fatalf("expected 3 functions after Dedup, got %d", len(p.funcs))
+	}
+
+	out, err := p.Execute(1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{6}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{6}, out)
+	}
+
+	// Wrappers made by reflect.MakeFunc share their code pointer but are distinct functions.
+	p, _ = New()
+	if err := p.AddWithBreaker(increment, 1, time.Second); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+	if err := p.AddWithBreaker(func(a int) int { return a * 10 }, 1, time.Second); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+	p.Dedup()
+	out, err = p.Execute(1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{20}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{20}, out)
+	}
+}
+
+func TestPipe_ExecuteUntil(t *testing.T) {
+	p, err := New(func(a float64) float64 { return a / 2 })
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	small := func(outputs []interface{}) bool { return outputs[0].(float64) < 1 }
+
+	out, err := p.ExecuteUntil(small, 10, 10.0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{0.625}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{0.625}, out)
+	}
+
+	if _, err := p.ExecuteUntil(small, 2, 10.0); err == nil {
+		t.Error("expected an error when exceeding maxIter")
+	}
+}
+
+func TestPipe_AddKeyword(t *testing.T) {
+	p, err := New(func(a, b int) map[string]interface{} {
+		return map[string]interface{}{"name": "total", "value": a + b}
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	err = p.AddKeyword(func(value int, name string) string {
+		return fmt.Sprintf("%s=%d", name, value)
+	}, []string{"value", "name"})
+	if err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+
+	out, err := p.Execute(1, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(out) == 0 || out[0] != "total=3" {
+		t.Errorf("output mismatch: expected %v, got %v", "total=3", out)
+	}
+
+	p, _ = New()
+	if err := p.AddKeyword(func(value int) int { return value }, []string{"missing"}); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+	if _, err := p.Execute(map[string]interface{}{"value": 1}); err == nil {
+		t.Error("expected an error for a missing key")
+	}
+
+	p, _ = New()
+	if err := p.AddKeyword(func(e error) bool { return e == nil }, []string{"err"}); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+	out, err = p.Execute(map[string]interface{}{"err": nil})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{true}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{true}, out)
+	}
+
+	if err := p.AddKeyword(nil, nil); err == nil {
+		t.Error("expected an error for a nil function")
+	}
+	if err := p.AddKeyword(func(a ...int) {}, []string{"a"}); err == nil {
+		t.Error("expected an error for a variadic function")
+	}
+}
+
+func TestPipe_ExecuteWhile(t *testing.T) {
+	var called bool
+	p, err := New(
+		func(a int) int { return a + 1 },
+		func(a int) int { return a * 10 },
+		func(a int) int {
+			called = true
+			return a
+		},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	out, err := p.ExecuteWhile(func(index int, outputs []interface{}) bool {
+		return outputs[0].(int) < 100
+	}, 9)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{100}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{100}, out)
+	}
+	if called {
+		t.Error("expected the third function not to be called")
+	}
+}
+
+func TestPipe_SetFuncs(t *testing.T) {
+	p, err := New(double)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	if err := p.SetFuncs(increment, "not a function"); err == nil {
+		t.Error("expected an error for an invalid argument")
+	}
+	if out, err := p.Execute(1); err != nil || !reflect.DeepEqual(out, []interface{}{2}) {
+		t.Errorf("expected the pipe to be unchanged, got %v, %v", out, err)
+	}
+
+	if err := p.SetFuncs(increment, increment); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out, err := p.Execute(1); err != nil || !reflect.DeepEqual(out, []interface{}{3}) {
+		t.Errorf("expected the functions to be replaced, got %v, %v", out, err)
+	}
+}
+
+type ValidationError struct {
+	Field string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid " + e.Field
+}
+
+func TestPipe_ErrorsAs(t *testing.T) {
+	p, err := New(
+		func(name string) (string, error) {
+			if name == "" {
+				return "", &ValidationError{Field: "name"}
+			}
+			return name, nil
+		},
+		func(name string) string { return "hello " + name },
+	)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	_, err = p.Execute("")
+	var ve *ValidationError
+	if !errors.As(err, &ve) {
+		t.Fatalf("expected a *ValidationError, got %v", err)
+	}
+	if ve.Field != "name" {
+		t.Errorf("field mismatch: expected %q, got %q", "name", ve.Field)
+	}
+
+	var se *StageError
+	if !errors.As(err, &se) || se.Index != 0 {
+		t.Errorf("expected a *StageError for function 0, got %v", err)
+	}
+}
+
+func TestPipe_ExecuteEachConcurrent(t *testing.T) {
+	p, err := New(func(a int) int {
+		if a == 3 {
+			panic("unlucky")
+		}
+		return a * a
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	results := p.ExecuteEachConcurrent([]interface{}{1, 2, 3, 4}, 2)
+	if len(results) != 4 {
+		t.Fatalf("expected 4 results, got %d", len(results))
+	}
+	for i, r := range results {
+		if i == 2 {
+			var pe *PanicError
+			if !errors.As(r.Err, &pe) {
+				t.Errorf("item %d: expected a *PanicError, got %v", i, r.Err)
+			}
+			continue
+		}
+		if r.Err != nil {
+			t.Errorf("item %d: unexpected error: %v", i, r.Err)
+			continue
+		}
+		if expected := []interface{}{(i + 1) * (i + 1)}; !reflect.DeepEqual(r.Outputs, expected) {
+			t.Errorf("item %d: output mismatch: expected %v, got %v", i, expected, r.Outputs)
+		}
+	}
+}
+
+func TestPipe_Go(t *testing.T) {
+	p, err := New(strconv.Atoi, func(a int) int {
+		if a == 3 {
+			panic("unlucky")
+		}
+		return a * a
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	type result struct {
+		out []interface{}
+		err error
+	}
+	results := make(chan result, 2)
+	done := func(out []interface{}, err error) { results <- result{out, err} }
+
+	p.Go(done, "4")
+	r := <-results
+	if r.err != nil || !reflect.DeepEqual(r.out, []interface{}{16}) {
+		t.Errorf("expected [16], got %v, %v", r.out, r.err)
+	}
+
+	p.Go(done, "3")
+	r = <-results
+	var pe *PanicError
+	if !errors.As(r.err, &pe) || pe.Index != 1 || pe.Value != "unlucky" {
+		t.Errorf("expected a *PanicError of function 1, got %v", r.err)
+	}
+
+	p.SetOutputTransform(func([]interface{}) ([]interface{}, error) { panic("transform") })
+	p.Go(done, "4")
+	r = <-results
+	if !errors.As(r.err, &pe) || pe.Index != -1 || pe.Value != "transform" {
+		t.Errorf("expected a *PanicError outside functions, got %v", r.err)
+	}
+
+	select {
+	case r := <-results:
+		t.Errorf("expected done to be called once per execution, got an extra call with %v, %v", r.out, r.err)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestPipe_RecordExecution(t *testing.T) {
+	p, err := New(func(s []string) []string {
+		s[0] = strings.TrimSpace(s[0])
+		return s
+	}, func(s []string) string { return s[0] }, strconv.Atoi)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	rec, out, err := p.RecordExecution([]string{" 42 "})
+	if err != nil || !reflect.DeepEqual(out, []interface{}{42}) {
+		t.Fatalf("expected [42], got %v, %v", out, err)
+	}
+	if !reflect.DeepEqual(rec.Args, []interface{}{[]string{" 42 "}}) {
+		t.Errorf("expected the original arguments to be recorded, got %v", rec.Args)
+	}
+	if len(rec.Stages) != 3 {
+		t.Fatalf("expected 3 recorded functions, got %d", len(rec.Stages))
+	}
+	if s := rec.Stages[2]; s.Index != 2 || !reflect.DeepEqual(s.Inputs, []interface{}{"42"}) || !reflect.DeepEqual(s.Outputs, []interface{}{42}) {
+		t.Errorf("unexpected record of function 2: %+v", s)
+	}
+	replayed, err := rec.Replay(p)
+	if err != nil || !reflect.DeepEqual(replayed, out) {
+		t.Errorf("expected the replay to return %v, got %v, %v", out, replayed, err)
+	}
+
+	rec, _, err = p.RecordExecution([]string{"x"})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(rec.Stages) != 3 || rec.Stages[2].Err == nil {
+		t.Errorf("expected the failing function to be recorded with its error, got %+v", rec.Stages)
+	}
+	if _, replayErr := rec.Replay(p); replayErr == nil || replayErr.Error() != err.Error() {
+		t.Errorf("expected the replay to fail with %v, got %v", err, replayErr)
+	}
+}
+
+func TestPipe_TypeMismatchError(t *testing.T) {
+	p, err := New(
+		func(a int) (int, string) { return a, "x" },
+		func(a int, b float64) float64 { return float64(a) * b },
+	)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	_, err = p.Execute(1)
+	var tme *TypeMismatchError
+	if !errors.As(err, &tme) {
+		t.Fatalf("expected a *TypeMismatchError, got %v", err)
+	}
+	if tme.Index != 1 || tme.ParamIndex != 1 {
+		t.Errorf("unexpected position: function %d, parameter %d", tme.Index, tme.ParamIndex)
+	}
+	if tme.Expected != reflect.TypeOf(0.0) || tme.Actual != reflect.TypeOf("") {
+		t.Errorf("unexpected types: expected %v, actual %v", tme.Expected, tme.Actual)
+	}
+}
+
+type sumStage struct {
+	runs int
+}
+
+func (s *sumStage) Run(inputs []interface{}) ([]interface{}, error) {
+	s.runs++
+	var sum int
+	for _, in := range inputs {
+		n, ok := in.(int)
+		if !ok {
+			return nil, fmt.Errorf("unexpected input %v", in)
+		}
+		sum += n
+	}
+	return []interface{}{sum}, nil
+}
+
+func TestPipe_AddStage(t *testing.T) {
+	p, err := New(func(a int) (int, int, int) { return a, a + 1, a + 2 })
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	s := &sumStage{}
+	if err := p.AddStage(s); err != nil {
+		t.Fatalf("unexpected error adding stage to pipe: %v", err)
+	}
+	if err := p.Add(strconv.Itoa); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+
+	out, err := p.Execute(1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{"6"}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{"6"}, out)
+	}
+	if s.runs != 1 {
+		t.Errorf("expected the stage to run once, ran %d times", s.runs)
+	}
+
+	p, _ = New(strconv.Itoa)
+	p.AddStage(s)
+	var se *StageError
+	if _, err := p.Execute(1); !errors.As(err, &se) || se.Index != 1 {
+		t.Errorf("expected a *StageError for stage 1, got %v", err)
+	}
+}
+
+func TestPipe_SetErrorStops(t *testing.T) {
+	errNotFound := errors.New("not found")
+	p, err := New(
+		func(key string) (string, error) { return "", errNotFound },
+		func(value string, err error) string {
+			if err != nil {
+				return "default"
+			}
+			return value
+		},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	if _, err := p.Execute("key"); !errors.Is(err, errNotFound) {
+		t.Errorf("expected %v by default, got %v", errNotFound, err)
+	}
+
+	p.SetErrorStops(false)
+	out, err := p.Execute("key")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{"default"}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{"default"}, out)
+	}
+}
+
+func TestPipe_ExecuteProfiled(t *testing.T) {
+	p, err := New(
+		func(a int) int {
+			time.Sleep(10 * time.Millisecond)
+			return a
+		},
+		func(a int) (int, error) {
+			if a < 0 {
+				return 0, errors.New("negative")
+			}
+			return a, nil
+		},
+		func(a int) int { return a },
+	)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	_, profile, err := p.ExecuteProfiled(1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(profile) != 3 {
+		t.Fatalf("expected a profile of 3 functions, got %d", len(profile))
+	}
+	if profile[0] < 10*time.Millisecond {
+		t.Errorf("expected the first function to take at least 10ms, got %v", profile[0])
+	}
+
+	_, profile, err = p.ExecuteProfiled(-1)
+	if err == nil {
+		t.Fatal("expected an error but got nil")
+	}
+	if len(profile) != 2 {
+		t.Errorf("expected a profile of 2 functions, got %d", len(profile))
+	}
+}
+
+func TestPipe_AnalyzeReachability(t *testing.T) {
+	p, err := New(
+		func(a int) int { return a },
+		func(a int) error { return nil },
+		func(a int) int { return a },
+		func(a int) int { return a },
+	)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	if unreachable := p.AnalyzeReachability(); !reflect.DeepEqual(unreachable, []int{2, 3}) {
+		t.Errorf("expected functions 2 and 3 to be unreachable, got %v", unreachable)
+	}
+
+	p, _ = New(strconv.Itoa, strconv.Atoi)
+	if unreachable := p.AnalyzeReachability(); len(unreachable) != 0 {
+		t.Errorf("expected all functions to be reachable, got %v", unreachable)
+	}
+}
+
+func TestPipe_FindSinks(t *testing.T) {
+	p, err := New(
+		strings.TrimSpace,
+		func(s string) { fmt.Fprint(io.Discard, s) },
+		func() (int, error) { return 42, nil },
+		func(n int) error { return nil },
+	)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	if err := p.AddStage(identityStage{}); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+
+	if sinks := p.FindSinks(); !reflect.DeepEqual(sinks, []int{1, 3}) {
+		t.Errorf("expected functions 1 and 3 to be sinks, got %v", sinks)
+	}
+}
+
+func TestPipe_EnableTypeBus(t *testing.T) {
+	p, err := New(
+		func(id int) (string, int) { return "user" + strconv.Itoa(id), id },
+		func(id int) float64 { return float64(id) * 1.5 },
+		func(score float64, name string) string { return fmt.Sprintf("%s: %.1f", name, score) },
+	)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	if _, err := p.Execute(2); err == nil {
+		t.Error("expected an error without the type bus")
+	}
+
+	p.EnableTypeBus(true)
+	out, err := p.Execute(2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{"user2: 3.0"}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{"user2: 3.0"}, out)
+	}
+}
+
+func TestPipe_Shift(t *testing.T) {
+	p, err := New(double, increment, strconv.Itoa)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	f, err := p.Shift()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !sameFunc(f, double) {
+		t.Error("expected the first function to be returned")
+	}
+	if out, err := p.Execute(1); err != nil || !reflect.DeepEqual(out, []interface{}{"2"}) {
+		t.Errorf("unexpected result after Shift: %v, %v", out, err)
+	}
+
+	p, _ = New()
+	if _, err := p.Shift(); err == nil {
+		t.Error("expected an error on an empty pipe")
+	}
+}
+
+func TestPipe_Pop(t *testing.T) {
+	p, err := New(double, increment, strconv.Itoa)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	f, err := p.Pop()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !sameFunc(f, strconv.Itoa) {
+		t.Error("expected the last function to be returned")
+	}
+	if out, err := p.Execute(1); err != nil || !reflect.DeepEqual(out, []interface{}{3}) {
+		t.Errorf("unexpected result after Pop: %v, %v", out, err)
+	}
+
+	p, _ = New()
+	if _, err := p.Pop(); err == nil {
+		t.Error("expected an error on an empty pipe")
+	}
+}
+
+func TestStop(t *testing.T) {
+	var called bool
+	p, err := New(
+		func(a int) int { return a * 2 },
+		func(a int) (int, error) {
+			if a > 10 {
+				return 0, Stop("value is large enough")
+			}
+			return a, nil
+		},
+		func(a int) int {
+			called = true
+			return a
+		},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	out, err := p.Execute(6)
+	if !errors.Is(err, ErrStop) {
+		t.Fatalf("expected an error wrapping ErrStop, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "value is large enough") {
+		t.Errorf("expected the error to contain the stop message, got %q", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{12}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{12}, out)
+	}
+	if called {
+		t.Error("expected the third function not to be called")
+	}
+}
+
+func TestPipe_NilArgument(t *testing.T) {
+	type config struct {
+		factor int
+	}
+	p, err := New(func(c *config, a int) int {
+		if c == nil {
+			return a
+		}
+		return a * c.factor
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	out, err := p.Execute(nil, 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{3}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{3}, out)
+	}
+
+	if _, err := p.Execute(nil, 3, "extra"); err != nil {
+		t.Errorf("unexpected error with extra arguments: %v", err)
+	}
+	if _, err := p.Execute(&config{factor: 2}, nil); err == nil {
+		t.Error("expected an error for a nil int argument")
+	}
+}
+
+func TestPipe_ExecuteAll(t *testing.T) {
+	p, err := New(double, increment, strconv.Itoa)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	perStage, err := p.ExecuteAll(4)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := [][]interface{}{{8}, {9}, {"9"}}
+	if !reflect.DeepEqual(perStage, expected) {
+		t.Errorf("outputs mismatch: expected %v, got %v", expected, perStage)
+	}
+
+	perStage, err = p.ExecuteAll("4")
+	if err == nil {
+		t.Fatal("expected an error but got nil")
+	}
+	if len(perStage) != 0 {
+		t.Errorf("expected no outputs, got %v", perStage)
+	}
+}
+
+func TestPipe_Snapshot(t *testing.T) {
+	p, err := New(double, strconv.Itoa)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	view := p.Snapshot()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			p.Add(strings.TrimSpace)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if view.Len() != 2 || len(view.Types()) != 2 || len(view.Names()) != 2 {
+			t.Fatalf("expected the snapshot to be unaffected by later changes, got %d functions", view.Len())
+		}
+	}
+	wg.Wait()
+
+	if view.Types()[1] != reflect.TypeOf(strconv.Itoa) {
+		t.Errorf("type mismatch: expected %v, got %v", reflect.TypeOf(strconv.Itoa), view.Types()[1])
+	}
+	if name := view.Names()[1]; name != "strconv.Itoa" {
+		t.Errorf("name mismatch: expected %q, got %q", "strconv.Itoa", name)
+	}
+	if n := p.Snapshot().Len(); n != 102 {
+		t.Errorf("expected a new snapshot to have 102 functions, got %d", n)
+	}
+}
+
+func TestAddTyped(t *testing.T) {
+	p, err := New()
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	if err := AddTyped(p, func(a int) string { return strconv.Itoa(a * 2) }); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+	if err := AddTyped(p, func(s string) int { return len(s) }); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+	// AddTyped[int, string](p, strconv.Atoi) doesn't compile: strconv.Atoi returns two values.
+
+	if err := p.Validate(); err != nil {
+		t.Errorf("unexpected validation error: %v", err)
+	}
+	out, err := p.Execute(50)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{3}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{3}, out)
+	}
+}
+
+func TestPipe_ExecutePartial(t *testing.T) {
+	p, err := New(strings.TrimSpace, strconv.Atoi, func(n int) int { return n * 2 })
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	out, err := p.ExecutePartial(" 21 ")
+	if err != nil || !reflect.DeepEqual(out, []interface{}{42}) {
+		t.Errorf("expected [42], got %v, %v", out, err)
+	}
+
+	out, err = p.ExecutePartial(" x ")
+	var stageErr *StageError
+	if !errors.As(err, &stageErr) || stageErr.Index != 1 {
+		t.Fatalf("expected a *StageError of function 1, got %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{"x"}) {
+		t.Errorf("expected the outputs of function 0, got %v", out)
+	}
+	if out, err := p.Execute(" x "); err == nil || out != nil {
+		t.Errorf("expected Execute to return no outputs on failure, got %v, %v", out, err)
+	}
+
+	p, _ = New(strconv.Atoi)
+	if out, err := p.ExecutePartial("x"); err == nil || !reflect.DeepEqual(out, []interface{}{"x"}) {
+		t.Errorf("expected the arguments alongside the error, got %v, %v", out, err)
+	}
+}
+
+func TestPipe_Execute_variadic(t *testing.T) {
+	p, err := New(func(a ...int) int { return len(a) })
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	for _, test := range []struct {
+		args     []interface{}
+		expected int
+	}{
+		{[]interface{}{5}, 1},
+		{[]interface{}{5, 6, 7}, 3},
+		{[]interface{}{5, 6, "x", 7}, 2},
+		{[]interface{}{[]int{5, 6}}, 2},
+	} {
+		out, err := p.Execute(test.args...)
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", test.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(out, []interface{}{test.expected}) {
+			t.Errorf("%v: output mismatch: expected %v, got %v", test.args, []interface{}{test.expected}, out)
+		}
+	}
+	if _, err := p.Execute("x"); err == nil {
+		t.Error("expected an error for an argument not matching the variadic parameter")
+	}
+
+	p, _ = New(func() (int, int) { return 1, 2 }, func(prefix string, a ...int) string { return prefix })
+	if err := p.Validate(); err == nil {
+		t.Error("expected a validation error for a missing non-variadic argument")
+	}
+	p, _ = New(func() (string, int, int) { return "s", 1, 2 }, func(prefix string, a ...int) string {
+		return fmt.Sprint(prefix, a)
+	})
+	if err := p.Validate(); err != nil {
+		t.Errorf("unexpected validation error: %v", err)
+	}
+	if out, err := p.Execute(); err != nil || !reflect.DeepEqual(out, []interface{}{"s[1 2]"}) {
+		t.Errorf("expected [s[1 2]], got %v, %v", out, err)
+	}
+}
+
+func TestPipe_ExecuteSlice(t *testing.T) {
+	p, err := New(func(a, b int) int { return a - b })
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	args := []interface{}{10, 4}
+	out, err := p.ExecuteSlice(args)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	spread, spreadErr := p.Execute(args...)
+	if !reflect.DeepEqual(out, spread) || spreadErr != nil {
+		t.Errorf("expected the same result as Execute(args...): %v, got %v", spread, out)
+	}
+
+	_, err = p.ExecuteSlice([]interface{}{10})
+	_, spreadErr = p.Execute(10)
+	if err == nil || spreadErr == nil || err.Error() != spreadErr.Error() {
+		t.Errorf("expected the same error as Execute(args...): %v, got %v", spreadErr, err)
+	}
+}
+
+type userID string
+
+type userIDKey struct{}
+
+func TestPipe_BindContextValue(t *testing.T) {
+	p, err := New(
+		func(item string) string { return strings.ToUpper(item) },
+		func(id userID, item string) string { return fmt.Sprintf("%s ordered %s", id, item) },
+		func(s string) string { return s + "." },
+	)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	p.BindContextValue(reflect.TypeOf(userID("")), userIDKey{})
+
+	if err := p.Validate(); err != nil {
+		t.Errorf("unexpected validation error: %v", err)
+	}
+
+	ctx := context.WithValue(context.Background(), userIDKey{}, userID("alice"))
+	out, err := p.ExecuteContext(ctx, "book")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{"alice ordered BOOK."}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{"alice ordered BOOK."}, out)
+	}
+}
+
+func TestPipe_EstimateCost(t *testing.T) {
+	short, err := New(double)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	long, err := New(double, increment, strconv.Itoa)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	if s, l := short.EstimateCost(), long.EstimateCost(); s >= l {
+		t.Errorf("expected the longer pipe to cost more: %d >= %d", s, l)
+	}
+}
+
+func TestPipe_AddWithBreaker(t *testing.T) {
+	const cooldown = 20 * time.Millisecond
+
+	var calls int
+	failing := true
+	p, err := New()
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	err = p.AddWithBreaker(func(a int) (int, error) {
+		calls++
+		if failing {
+			return 0, errors.New("service unavailable")
+		}
+		return a, nil
+	}, 2, cooldown)
+	if err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := p.Execute(1); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("call %d: expected the function's error, got %v", i, err)
+		}
+	}
+	if _, err := p.Execute(1); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected %v, got %v", ErrCircuitOpen, err)
+	}
+	if calls != 2 {
+		t.Errorf("expected the function not to be called while the breaker is open, got %d calls", calls)
+	}
+
+	time.Sleep(cooldown)
+	failing = false
+	out, err := p.Execute(1)
+	if err != nil {
+		t.Fatalf("expected the breaker to recover, got %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{1}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{1}, out)
+	}
+
+	if err := p.AddWithBreaker(nil, 1, cooldown); err == nil {
+		t.Error("expected an error for a nil function")
+	}
+	if err := p.AddWithBreaker(Identity(), 1, cooldown); err == nil {
+		t.Error("expected an error for a Stage")
+	}
+}
+
+func TestPipe_ConcurrentAdd(t *testing.T) {
+	const goroutines, adds = 8, 100
+
+	p, err := New()
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < adds; i++ {
+				if err := p.Add(increment); err != nil {
+					t.Errorf("unexpected error adding functions to pipe: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := p.Len(); n != goroutines*adds {
+		t.Fatalf("expected %d functions, got %d", goroutines*adds, n)
+	}
+	out, err := p.Execute(0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{goroutines * adds}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{goroutines * adds}, out)
+	}
+}
+
+type celsius float64
+
+func TestPipe_SetTypeMatcher(t *testing.T) {
+	p, err := New(strconv.Atoi, math.Sqrt)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	if _, err := p.Execute("16"); err == nil {
+		t.Fatal("expected an int not to be passed to a float64 parameter")
+	}
+
+	p.SetTypeMatcher(func(argType, paramType reflect.Type) bool {
+		return argType.Kind() == reflect.Int && paramType.Kind() == reflect.Float64
+	})
+	out, err := p.Execute("16")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{4.0}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{4.0}, out)
+	}
+
+	// A lying matcher makes the call panic.
+	p, _ = New(strings.TrimSpace, func(s fmt.Stringer) string { return s.String() })
+	p.SetRecoverPanics(true)
+	p.SetTypeMatcher(func(argType, paramType reflect.Type) bool { return true })
+	_, err = p.Execute("a")
+	var pe *PanicError
+	if !errors.As(err, &pe) || pe.Index != 1 {
+		t.Errorf("expected a *PanicError of function 1, got %v", err)
+	}
+}
+
+func TestPipe_SetValueBuilder(t *testing.T) {
+	p, err := New(
+		func(s string) string { return strings.TrimSuffix(s, "C") },
+		func(c celsius) float64 { return float64(c)*9/5 + 32 },
+	)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	if _, err := p.Execute("100C"); err == nil {
+		t.Error("expected an error with the default builder")
+	}
+
+	p.SetValueBuilder(func(arg interface{}, wantType reflect.Type) (reflect.Value, error) {
+		if s, ok := arg.(string); ok && wantType == reflect.TypeOf(celsius(0)) {
+			f, err := strconv.ParseFloat(s, 64)
+			return reflect.ValueOf(celsius(f)), err
+		}
+		return reflect.ValueOf(arg), nil
+	})
+	out, err := p.Execute("100C")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{212.0}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{212.0}, out)
+	}
+}
+
+func TestPipe_Optimize(t *testing.T) {
+	p, err := New(double, Identity(), increment, Identity(), Identity())
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	before, err := p.Execute(5)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	p.Optimize()
+	if n := p.Len(); n != 2 {
+		t.Errorf("expected 2 functions after Optimize, got %d", n)
+	}
+	after, err := p.Execute(5)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(before, after) || !reflect.DeepEqual(after, []interface{}{11}) {
+		t.Errorf("expected Optimize not to change the outputs: %v before, %v after", before, after)
+	}
+}
+
+func TestPipe_ExecuteStruct(t *testing.T) {
+	type request struct {
+		Name  string
+		Times int
+	}
+	p, err := New(strings.Repeat)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	out, err := p.ExecuteStruct(request{Name: "ab", Times: 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{"ababab"}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{"ababab"}, out)
+	}
+
+	if _, err := p.ExecuteStruct(&request{Name: "a", Times: 1}); err != nil {
+		t.Errorf("unexpected error with a pointer to a struct: %v", err)
+	}
+	if _, err := p.ExecuteStruct("ab"); err == nil {
+		t.Error("expected an error for a non-struct argument")
+	}
+
+	type private struct {
+		Name  string
+		times int
+	}
+	_, err = p.ExecuteStruct(private{Name: "ab", times: 3})
+	if err == nil || !strings.Contains(err.Error(), "field times") || !strings.Contains(err.Error(), "unexported") {
+		t.Errorf("expected an error naming the unexported field, got %v", err)
+	}
+}
+
+func TestPipe_SetOutputTransform(t *testing.T) {
+	p, err := New(func(s string) ([]byte, []byte) {
+		return []byte(strings.ToUpper(s)), []byte(s)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	p.SetOutputTransform(func(outputs []interface{}) ([]interface{}, error) {
+		var joined []byte
+		for _, o := range outputs {
+			b, ok := o.([]byte)
+			if !ok {
+				return nil, fmt.Errorf("unexpected output %v", o)
+			}
+			joined = append(joined, b...)
+		}
+		return []interface{}{joined}, nil
+	})
+
+	out, err := p.Execute("go")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{[]byte("GOgo")}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{[]byte("GOgo")}, out)
+	}
+}
+
+func TestPipe_NilErrorDropped(t *testing.T) {
+	p, err := New(
+		func(s string) (string, int, error) {
+			n, err := strconv.Atoi(s)
+			return s, n, err
+		},
+		func(s string, n int) string { return fmt.Sprintf("%s=%d", s, n) },
+	)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	out, err := p.Execute("42")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{"42=42"}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{"42=42"}, out)
+	}
+
+	var numErr *strconv.NumError
+	if _, err := p.Execute("x"); !errors.As(err, &numErr) {
+		t.Errorf("expected a *strconv.NumError, got %v", err)
+	}
+}
+
+func TestNewFromValues(t *testing.T) {
+	p, err := NewFromValues(reflect.ValueOf(double), reflect.ValueOf(strconv.Itoa))
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	out, err := p.Execute(21)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{"42"}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{"42"}, out)
+	}
+
+	if _, err := NewFromValues(reflect.ValueOf(1)); err == nil {
+		t.Error("expected an error for a non-function value")
+	}
+	if _, err := NewFromValues(reflect.Value{}); err == nil {
+		t.Error("expected an error for an invalid value")
+	}
+}
+
+func TestPipe_ExecuteBudgeted(t *testing.T) {
+	p, err := New(
+		func(a int) int {
+			time.Sleep(5 * time.Millisecond)
+			return a
+		},
+		func(a int) int {
+			time.Sleep(200 * time.Millisecond)
+			return a
+		},
+		func(a int) int { return a },
+	)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+	_, err = p.ExecuteBudgeted(ctx, 1)
+	if !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("expected an error wrapping %v, got %v", ErrBudgetExceeded, err)
+	}
+	if !strings.Contains(err.Error(), "function 1") {
+		t.Errorf("expected the error to name function 1, got %q", err)
+	}
+
+	out, err := p.ExecuteBudgeted(context.Background(), 1)
+	if err != nil || !reflect.DeepEqual(out, []interface{}{1}) {
+		t.Errorf("unexpected result without a deadline: %v, %v", out, err)
+	}
+
+	// Without recovery, a panic reaches the caller of ExecuteBudgeted, as it does with Execute.
+	p, _ = New(func(a int) int { panic("boom") })
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Errorf("expected the panic to reach the caller, got %v", r)
+			}
+		}()
+		p.ExecuteBudgeted(ctx, 1)
+	}()
+}
+
+func TestPipe_DetectMutation(t *testing.T) {
+	p, err := New(
+		func(n int) []int { return []int{n, n + 1, n + 2} },
+		func(s []int) int {
+			s[0] = 100
+			return len(s)
+		},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	logger := &testLogger{}
+	p.SetLogger(logger)
+	p.DetectMutation(true)
+
+	if _, err := p.Execute(1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(logger.lines) != 1 || !strings.Contains(logger.lines[0], "function 1 mutated its argument 0") {
+		t.Errorf("expected a mutation warning for function 1, got %v", logger.lines)
+	}
+}
+
+func TestPipe_ExecuteIntoMap(t *testing.T) {
+	p, err := New(strings.TrimSpace)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	if _, err := p.ExecuteIntoMap(" a "); err == nil {
+		t.Error("expected an error when the outputs are not named")
+	}
+
+	err = p.AddNamedOutputs(func(s string) (string, int, error) {
+		return strings.ToUpper(s), len(s), nil
+	}, "upper", "length")
+	if err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+
+	m, err := p.ExecuteIntoMap(" abc ")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]interface{}{"upper": "ABC", "length": 3}
+	if !reflect.DeepEqual(m, expected) {
+		t.Errorf("output mismatch: expected %v, got %v", expected, m)
+	}
+
+	if err := p.AddNamedOutputs(strings.TrimSpace, "a", "b"); err == nil {
+		t.Error("expected an error for too many names")
+	}
+}
+
+func TestPipe_ExecuteToFixedPoint(t *testing.T) {
+	var runs int
+	p, err := New(func(s string) string {
+		runs++
+		return strings.ReplaceAll(strings.TrimSpace(s), "  ", " ")
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	out, err := p.ExecuteToFixedPoint(10, " a    b ")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{"a b"}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{"a b"}, out)
+	}
+	if runs != 3 {
+		t.Errorf("expected 3 runs (2 to stabilize, 1 to confirm), got %d", runs)
+	}
+
+	if _, err := p.ExecuteToFixedPoint(1, " a    b "); err == nil {
+		t.Error("expected an error when exceeding maxIter")
+	}
+}
+
+func TestPipe_AddMemoized(t *testing.T) {
+	var calls int
+	p, err := New()
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	err = p.AddMemoized(func(a, b int) int {
+		calls++
+		return a * b
+	}, func(inputs []interface{}) string { return fmt.Sprint(inputs...) })
+	if err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		out, err := p.Execute(6, 7)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(out, []interface{}{42}) {
+			t.Errorf("output mismatch: expected %v, got %v", []interface{}{42}, out)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected the function to be called once, got %d calls", calls)
+	}
+
+	p.Execute(2, 3)
+	p.ClearMemoCache()
+	p.Execute(6, 7)
+	if calls != 3 {
+		t.Errorf("expected the function to be called again after ClearMemoCache, got %d calls", calls)
+	}
+}
+
+func TestPipe_Reset(t *testing.T) {
+	var calls int
+	p, err := New(strings.TrimSpace)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	err = p.AddMemoized(func(s string) int {
+		calls++
+		return len(s)
+	}, func(inputs []interface{}) string { return fmt.Sprint(inputs...) })
+	if err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+	p.SetRetainLast(true)
+
+	p.Execute(" abc ")
+	if _, _, ok := p.LastStage(1); !ok {
+		t.Fatal("expected the last execution to be retained")
+	}
+	p.Reset()
+	if _, _, ok := p.LastStage(1); ok {
+		t.Error("expected the retained stage records to be cleared")
+	}
+
+	out, err := p.Execute(" abc ")
+	if err != nil || !reflect.DeepEqual(out, []interface{}{3}) {
+		t.Errorf("expected the functions to be kept, got %v, %v", out, err)
+	}
+	if calls != 2 {
+		t.Errorf("expected the memoized function to be called again after Reset, got %d calls", calls)
+	}
+}
+
+func TestPipe_Check(t *testing.T) {
+	p, err := New(
+		func(v interface{}) string { return fmt.Sprint(v) },
+		strconv.Atoi,
+		func(n int) int { return n * 2 },
+	)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	if err := p.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+	if err := p.Check(42); err != nil {
+		t.Errorf("unexpected check error: %v", err)
+	}
+
+	p, err = New(func(n int) string { return strconv.Itoa(n) }, strings.ToUpper)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	if err := p.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+	err = p.Check(int64(42))
+	var tme *TypeMismatchError
+	if !errors.As(err, &tme) {
+		t.Fatalf("expected a *TypeMismatchError, got %v", err)
+	}
+	if tme.Index != 0 || tme.Actual != reflect.TypeOf(int64(0)) {
+		t.Errorf("expected function 0 to get an int64, got function %d and %v", tme.Index, tme.Actual)
+	}
+	if err := p.Check(); err == nil {
+		t.Error("expected an error for missing arguments")
+	}
+}
+
+func TestPipe_CanAccept(t *testing.T) {
+	p, err := New(strings.Repeat, strconv.Atoi)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	// Only the first function is checked: "ab" repeated isn't a number, but is a string.
+	if err := p.CanAccept("ab", 3); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := p.CanAccept("ab"); err == nil || !strings.Contains(err.Error(), "takes 2 arguments") {
+		t.Errorf("expected an error for missing arguments, got %v", err)
+	}
+	err = p.CanAccept("ab", "3")
+	var tme *TypeMismatchError
+	if !errors.As(err, &tme) {
+		t.Fatalf("expected a *TypeMismatchError, got %v", err)
+	}
+	if tme.Index != 0 || tme.ParamIndex != 1 || tme.Actual != reflect.TypeOf("") {
+		t.Errorf("expected argument 1 of function 0 to get a string, got %+v", tme)
+	}
+
+	p, _ = New()
+	if err := p.CanAccept(1, 2); err != nil {
+		t.Errorf("unexpected error for an empty pipe: %v", err)
+	}
+	p.RequireStages(true)
+	if err := p.CanAccept(1, 2); !errors.Is(err, ErrNoStages) {
+		t.Errorf("expected %v, got %v", ErrNoStages, err)
+	}
+}
+
+func TestPipe_AssertContract(t *testing.T) {
+	stringType, intType := reflect.TypeOf(""), reflect.TypeOf(0)
+	p, err := New(strings.TrimSpace, strconv.Atoi, func(n int) (int, bool) { return n, n > 0 })
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	boolType := reflect.TypeOf(false)
+	if err := p.AssertContract([]reflect.Type{stringType}, []reflect.Type{intType, boolType}); err != nil {
+		t.Errorf("unexpected contract error: %v", err)
+	}
+
+	err = p.AssertContract([]reflect.Type{intType}, []reflect.Type{intType, boolType})
+	if err == nil || !strings.Contains(err.Error(), "pipe takes arguments (string), not (int)") {
+		t.Errorf("expected an arguments mismatch, got %v", err)
+	}
+	err = p.AssertContract([]reflect.Type{stringType}, []reflect.Type{intType})
+	if err == nil || !strings.Contains(err.Error(), "pipe returns outputs (int, bool), not (int)") {
+		t.Errorf("expected an outputs mismatch, got %v", err)
+	}
+
+	p, _ = New(strconv.Atoi)
+	p.SetReturnType(reflect.TypeOf(int64(0)))
+	if err := p.AssertContract([]reflect.Type{stringType}, []reflect.Type{reflect.TypeOf(int64(0))}); err != nil {
+		t.Errorf("unexpected contract error with a return type: %v", err)
+	}
+
+	p, _ = New()
+	if err := p.AddStage(identityStage{}); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+	if err := p.AssertContract(nil, nil); err == nil {
+		t.Error("expected an error for a pipe starting with a Stage")
+	}
+}
+
+var errNotFound = errors.New("not found")
+
+func TestPipe_AddWithErrorMap(t *testing.T) {
+	p, err := New()
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	err = p.AddWithErrorMap(func(key string) (string, error) {
+		return "", io.EOF
+	}, func(err error) error {
+		if errors.Is(err, io.EOF) {
+			return fmt.Errorf("lookup: %w", errNotFound)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+	if _, err := p.Execute("key"); !errors.Is(err, errNotFound) || errors.Is(err, io.EOF) {
+		t.Errorf("expected the error to be mapped to %v, got %v", errNotFound, err)
+	}
+
+	p, _ = New()
+	err = p.AddWithErrorMap(func(a int) (int, error) {
+		return a, io.EOF
+	}, func(err error) error {
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+	if err := p.Add(func(a int) int { return a * 2 }); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+	out, err := p.Execute(21)
+	if err != nil {
+		t.Fatalf("expected the benign error to be dropped, got %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{42}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{42}, out)
+	}
+
+	if err := p.AddWithErrorMap(func(a int) int { return a }, func(err error) error { return err }); err == nil {
+		t.Error("expected an error for a function without an error output")
+	}
+}
+
+func TestPipe_AddWithContext(t *testing.T) {
+	inner, err := New()
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	if err := inner.AddWithContext(strconv.Atoi, "parsing the quantity"); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+
+	p, _ := New(strings.TrimSpace)
+	err = p.AddWithContext(func(s string) (int, error) {
+		out, err := inner.Execute(s)
+		if err != nil {
+			return 0, err
+		}
+		return out[0].(int), nil
+	}, "reading the order")
+	if err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+
+	if out, err := p.Execute(" 3 "); err != nil || !reflect.DeepEqual(out, []interface{}{3}) {
+		t.Errorf("expected [3], got %v, %v", out, err)
+	}
+	_, err = p.Execute("three")
+	var numErr *strconv.NumError
+	if !errors.As(err, &numErr) {
+		t.Fatalf("expected the error to wrap a *strconv.NumError, got %v", err)
+	}
+	for _, msg := range []string{"function 1", "reading the order: ", "parsing the quantity: ", "invalid syntax"} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("expected the error to contain %q, got %q", msg, err)
+		}
+	}
+
+	if err := p.AddWithContext(strings.ToUpper, "upper"); err == nil {
+		t.Error("expected an error for a function without an error output")
+	}
+}
+
+func TestPipe_AddGuard(t *testing.T) {
+	var after int
+	p, err := New(strings.TrimSpace)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	err = p.AddGuard(func(inputs []interface{}) (bool, error) {
+		if inputs[0] == "fail" {
+			return false, io.EOF
+		}
+		return inputs[0] != "", nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+	if err := p.Add(func(s string) string { after++; return strings.ToUpper(s) }); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+
+	out, err := p.Execute(" a ")
+	if err != nil || !reflect.DeepEqual(out, []interface{}{"A"}) {
+		t.Errorf("expected the guard to forward its inputs, got %v, %v", out, err)
+	}
+
+	events := p.Subscribe()
+	rec, out, err := p.RecordExecution("   ")
+	if err != nil || !reflect.DeepEqual(out, []interface{}{""}) {
+		t.Errorf("expected the guard to stop with its inputs, got %v, %v", out, err)
+	}
+	if len(rec.Stages) != 2 || rec.Stages[1].Err != nil {
+		t.Errorf("expected the closed guard to be recorded without error, got %+v", rec.Stages)
+	}
+	for len(events) > 0 {
+		if e := <-events; e.Err != nil {
+			t.Errorf("expected no error in the events of a closed guard, got %v: %v", e.Type, e.Err)
+		}
+	}
+	p.Unsubscribe(events)
+
+	out, err = p.Execute("fail")
+	var stageErr *StageError
+	if !errors.As(err, &stageErr) || stageErr.Index != 1 || !errors.Is(err, io.EOF) {
+		t.Errorf("expected a *StageError of function 1 wrapping %v, got %v", io.EOF, err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{"fail"}) {
+		t.Errorf("expected the guard's inputs alongside its error, got %v", out)
+	}
+	if after != 1 {
+		t.Errorf("expected the function after the guard to run once, got %d", after)
+	}
+
+	if err := p.AddGuard(nil); err == nil {
+		t.Error("expected an error for a nil predicate")
+	}
+}
+
+func TestPipe_AddWithPriority(t *testing.T) {
+	var order []string
+	step := func(name string) func(string) string {
+		return func(s string) string {
+			order = append(order, name)
+			return s + name
+		}
+	}
+	p, err := New(step("a"))
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	for _, f := range []struct {
+		name     string
+		priority int
+	}{{"late", 10}, {"early", -10}, {"mid", 5}, {"late2", 10}} {
+		if err := p.AddWithPriority(step(f.name), f.priority); err != nil {
+			t.Fatalf("unexpected error adding functions to pipe: %v", err)
+		}
+	}
+	if err := p.Add(step("b")); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+
+	out, err := p.Execute("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"early", "a", "b", "mid", "late", "late2"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("order mismatch: expected %v, got %v", expected, order)
+	}
+	if !reflect.DeepEqual(out, []interface{}{"earlyabmidlatelate2"}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{"earlyabmidlatelate2"}, out)
+	}
+	if err := p.AddWithPriority(42, 0); err == nil {
+		t.Error("expected an error for a non-function")
+	}
+}
+
+func TestPipe_SetDefaultArgs(t *testing.T) {
+	p, err := New(strings.Repeat)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	p.SetDefaultArgs("ab", 2)
+
+	if out, err := p.Execute(); err != nil || !reflect.DeepEqual(out, []interface{}{"abab"}) {
+		t.Errorf("expected the defaults to be used, got %v, %v", out, err)
+	}
+	if out, err := p.Execute("x", 3); err != nil || !reflect.DeepEqual(out, []interface{}{"xxx"}) {
+		t.Errorf("expected explicit arguments to override the defaults, got %v, %v", out, err)
+	}
+
+	p.SetDefaultArgs()
+	if _, err := p.Execute(); err == nil {
+		t.Error("expected an error without arguments once the defaults are removed")
+	}
+
+	// Only the first iteration of a loop gets the defaults, the next ones get the outputs of
+	// the previous one, even if there are none.
+	var calls []int
+	loop, err := New(func(n int) { calls = append(calls, n) })
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	loop.SetDefaultArgs(1)
+	if _, err := loop.ExecuteUntil(func([]interface{}) bool { return false }, 2); err == nil {
+		t.Error("expected the second iteration to fail without arguments")
+	}
+	if !reflect.DeepEqual(calls, []int{1}) {
+		t.Errorf("expected a single call with the defaults, got %v", calls)
+	}
+
+	// The defaults are the arguments of the first tagged function, whatever its index.
+	tagged, err := New(strings.TrimSpace)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	if err := tagged.AddTagged(strings.ToUpper, "upper"); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+	tagged.SetDefaultArgs(" ab ")
+	if out, err := tagged.ExecuteTagged("upper"); err != nil || !reflect.DeepEqual(out, []interface{}{" AB "}) {
+		t.Errorf("expected the defaults to be passed to the tagged function, got %v, %v", out, err)
+	}
+}
+
+func TestPipe_UseSliceThreading(t *testing.T) {
+	p, err := New(
+		func(inputs []interface{}) []interface{} {
+			return append(inputs, len(inputs))
+		},
+		func(inputs []interface{}) ([]interface{}, error) {
+			if len(inputs) < 2 {
+				return nil, errors.New("not enough inputs")
+			}
+			return []interface{}{fmt.Sprintf("%d inputs: %v", len(inputs), inputs)}, nil
+		},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	p.UseSliceThreading(true)
+
+	for _, test := range []struct {
+		args     []interface{}
+		expected string
+	}{
+		{[]interface{}{"a"}, "2 inputs: [a 1]"},
+		{[]interface{}{"a", "b", "c"}, "4 inputs: [a b c 3]"},
+	} {
+		out, err := p.Execute(test.args...)
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", test.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(out, []interface{}{test.expected}) {
+			t.Errorf("%v: output mismatch: expected %v, got %v", test.args, []interface{}{test.expected}, out)
+		}
+	}
+
+	var stageErr *StageError
+	if _, err := p.Execute(); !errors.As(err, &stageErr) || stageErr.Index != 1 {
+		t.Errorf("expected a *StageError of function 1, got %v", err)
+	}
+}
+
+func TestPipe_SetMaxOutputs(t *testing.T) {
+	p, err := New()
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	err = p.AddSpread(func(n int) []interface{} {
+		return make([]interface{}, n)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+	p.SetMaxOutputs(3)
+
+	if out, err := p.Execute(3); err != nil || len(out) != 3 {
+		t.Errorf("expected 3 outputs, got %v, %v", out, err)
+	}
+	out, err := p.Execute(1000)
+	if !errors.Is(err, ErrTooManyOutputs) || out != nil {
+		t.Errorf("expected %v, got %v, %v", ErrTooManyOutputs, out, err)
+	}
+	if err != nil && !strings.Contains(err.Error(), "function 0 returned 1000 outputs, more than 3") {
+		t.Errorf("unexpected error message: %v", err)
+	}
+
+	p.SetMaxOutputs(0)
+	if out, err := p.Execute(1000); err != nil || len(out) != 1000 {
+		t.Errorf("expected 1000 outputs without a limit, got %d, %v", len(out), err)
+	}
+}
+
+func TestPipe_AddWithMapping(t *testing.T) {
+	p, err := New(func(s string) (string, int) { return s, len(s) })
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	// The function takes the count first, while the previous one returns it last.
+	if err := p.AddWithMapping(func(n int, s string) string { return strings.Repeat(s, n) }, []int{1, 0}); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+	out, err := p.Execute("ab")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{"abab"}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{"abab"}, out)
+	}
+
+	p, _ = New(func() (int, string, []int) { return 1, "sum", []int{2, 3} })
+	err = p.AddWithMapping(func(name string, nums ...int) string {
+		var total int
+		for _, n := range nums {
+			total += n
+		}
+		return fmt.Sprintf("%s=%d", name, total)
+	}, []int{1, 2})
+	if err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+	if out, err := p.Execute(); err != nil || !reflect.DeepEqual(out, []interface{}{"sum=5"}) {
+		t.Errorf("expected [sum=5], got %v, %v", out, err)
+	}
+
+	for _, order := range [][]int{{0}, {0, -1}, {0, 0}} {
+		if err := p.AddWithMapping(strings.Repeat, order); err == nil {
+			t.Errorf("%v: expected an error", order)
+		}
+	}
+}
+
+func TestPipe_AddSpread(t *testing.T) {
+	p, err := New()
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	err = p.AddSpread(func(s string) []interface{} {
+		return []interface{}{s, len(s), []interface{}{"nested"}}
+	})
+	if err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+	if err := p.Add(func(s string, n int, nested []interface{}) string {
+		return fmt.Sprintf("%s:%d:%d", s, n, len(nested))
+	}); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+	if err := p.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+
+	out, err := p.Execute("abc")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{"abc:3:1"}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{"abc:3:1"}, out)
+	}
+}
+
+func TestPipe_ExecuteRange(t *testing.T) {
+	p, err := New(
+		func(s string) (int, error) { return strconv.Atoi(s) },
+		func(a int) int { return a * 2 },
+		func(a int) string { return strconv.Itoa(a + 1) },
+	)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	out, err := p.ExecuteRange(1, 3, 20)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{"41"}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{"41"}, out)
+	}
+
+	// The return type only applies to the range ending with the last function.
+	p.SetReturnType(reflect.TypeOf(""))
+	if out, err := p.ExecuteRange(0, 1, "20"); err != nil || !reflect.DeepEqual(out, []interface{}{20}) {
+		t.Errorf("expected the untransformed outputs of the range, got %v, %v", out, err)
+	}
+	if out, err := p.ExecuteRange(1, 3, 20); err != nil || !reflect.DeepEqual(out, []interface{}{"41"}) {
+		t.Errorf("expected the outputs of the last function, got %v, %v", out, err)
+	}
+	p.SetReturnType(nil)
+
+	if _, err := p.ExecuteRange(2, 4, 20); err == nil {
+		t.Error("expected an error for a range exceeding the pipe")
+	}
+	if _, err := p.ExecuteRange(2, 1, 20); err == nil {
+		t.Error("expected an error for a reversed range")
+	}
+}
+
+func TestPipe_SetTimingHook(t *testing.T) {
+	p, err := New(
+		func(a int) int {
+			time.Sleep(10 * time.Millisecond)
+			return a
+		},
+		func(a int) (int, error) {
+			if a < 0 {
+				return 0, errors.New("negative")
+			}
+			return a, nil
+		},
+		func(a int) int { return a },
+	)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	var (
+		calls  int
+		total  time.Duration
+		stages int
+		last   error
+	)
+	p.SetTimingHook(func(d time.Duration, n int, err error) {
+		calls++
+		total, stages, last = d, n, err
+	})
+
+	if _, err := p.Execute(1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 1 || stages != 3 || last != nil {
+		t.Errorf("expected a single call for 3 functions without error, got %d calls, %d functions, %v", calls, stages, last)
+	}
+	if total < 10*time.Millisecond || total > time.Second {
+		t.Errorf("implausible total duration %v", total)
+	}
+
+	_, err = p.Execute(-1)
+	if stages != 2 || last == nil || last != err {
+		t.Errorf("expected 2 functions and the returned error, got %d functions, %v", stages, last)
+	}
+}
+
+func TestPipe_AllowDynamicStages(t *testing.T) {
+	p, err := New(
+		func(a int) (func(int) string, int) {
+			return func(b int) string { return strconv.Itoa(b * 10) }, a
+		},
+		func(s string) string { return s + "!" },
+	)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	if _, err := p.Execute(4); err == nil {
+		t.Error("expected an error without dynamic functions")
+	}
+
+	p.AllowDynamicStages(true)
+	if err := p.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+	out, err := p.Execute(4)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{"40!"}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{"40!"}, out)
+	}
+
+	// A function returning itself would run forever without a limit.
+	var loop func() interface{}
+	loop = func() interface{} { return loop }
+	p, _ = New(loop)
+	p.AllowDynamicStages(true)
+	p.SetMaxDynamicStages(10)
+	if _, err := p.Execute(); err == nil || !strings.Contains(err.Error(), "more than 10 dynamic functions") {
+		t.Errorf("expected an error exceeding the limit, got %v", err)
+	}
+}
+
+func TestPipe_IsPure(t *testing.T) {
+	p, err := New(double, increment, func(a int) float64 { return float64(a) / 2 })
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	if !p.IsPure() {
+		t.Error("expected a numeric pipe to be pure")
+	}
+
+	if err := p.Add(func(f float64) (string, error) { return strconv.FormatFloat(f, 'f', -1, 64), nil }); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+	if p.IsPure() {
+		t.Error("expected a pipe with an error-returning function not to be pure")
+	}
+
+	p, _ = New(func(ctx context.Context, a int) int { return a })
+	if p.IsPure() {
+		t.Error("expected a pipe with a function taking a context not to be pure")
+	}
+}
+
+func TestPipe_ExecuteWithPool(t *testing.T) {
+	p, err := New(strings.Repeat, strings.ToUpper, func(s string) int { return len(s) })
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	var pool sync.Pool
+	for i := 0; i < 3; i++ {
+		out, err := p.ExecuteWithPool(&pool, "ab", i+1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(out, []interface{}{2 * (i + 1)}) {
+			t.Errorf("output mismatch: expected %v, got %v", []interface{}{2 * (i + 1)}, out)
+		}
+	}
+}
+
+func benchmarkPipe(b *testing.B) *Pipe {
+	p, err := New(double, increment, double, increment)
+	if err != nil {
+		b.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	return p
+}
+
+func BenchmarkPipe_Execute(b *testing.B) {
+	p := benchmarkPipe(b)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		p.Execute(i)
+	}
+}
+
+func BenchmarkPipe_ExecuteWithPool(b *testing.B) {
+	p := benchmarkPipe(b)
+	var pool sync.Pool
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		p.ExecuteWithPool(&pool, i)
+	}
+}
+
+func rawDouble(inputs []interface{}) ([]interface{}, error) {
+	return []interface{}{inputs[0].(int) * 2}, nil
+}
+
+func rawIncrement(inputs []interface{}) ([]interface{}, error) {
+	return []interface{}{inputs[0].(int) + 1}, nil
+}
+
+func BenchmarkPipe_ExecuteRaw(b *testing.B) {
+	p, _ := New()
+	for _, f := range []func([]interface{}) ([]interface{}, error){rawDouble, rawIncrement, rawDouble, rawIncrement} {
+		if err := p.AddRaw(f); err != nil {
+			b.Fatalf("unexpected error adding functions to pipe: %v", err)
+		}
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		p.Execute(i)
+	}
+}
+
+func TestPipe_AddRaw(t *testing.T) {
+	p, err := New()
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	var got []interface{}
+	err = p.AddRaw(func(inputs []interface{}) ([]interface{}, error) {
+		got = inputs
+		return []interface{}{len(inputs)}, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+	if err := p.Add(double); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+
+	// A raw function takes any values, even a Lazy one, which it gets unevaluated.
+	lazy := Lazy(func() interface{} { panic("evaluated") })
+	out, err := p.Execute("a", nil, lazy)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{6}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{6}, out)
+	}
+	if len(got) != 3 || got[0] != "a" || got[1] != nil {
+		t.Errorf("expected the raw function to get the arguments as they are, got %v", got)
+	}
+
+	p, _ = New()
+	if err := p.AddRaw(func([]interface{}) ([]interface{}, error) { return nil, io.EOF }); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+	var stageErr *StageError
+	if _, err := p.Execute(); !errors.As(err, &stageErr) || stageErr.Index != 0 || !errors.Is(err, io.EOF) {
+		t.Errorf("expected a *StageError of function 0 wrapping %v, got %v", io.EOF, err)
+	}
+	if err := p.AddRaw(nil); err == nil {
+		t.Error("expected an error for a nil function")
+	}
+
+	// Raw functions are called without building reflect values for their arguments.
+	raw, _ := New()
+	raw.AddRaw(rawDouble)
+	raw.AddRaw(rawIncrement)
+	reflected, _ := New(double, increment)
+	rawAllocs := testing.AllocsPerRun(100, func() { raw.Execute(1) })
+	reflectedAllocs := testing.AllocsPerRun(100, func() { reflected.Execute(1) })
+	if rawAllocs >= reflectedAllocs {
+		t.Errorf("expected raw functions to allocate less than reflected ones, got %v and %v allocations", rawAllocs, reflectedAllocs)
+	}
+}
+
+func TestPipe_AddTagged(t *testing.T) {
+	p, err := New(strings.TrimSpace)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	if err := p.AddTagged(strconv.Atoi, "cpu", "parse"); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+	if err := p.AddTagged(func(n int) error { return nil }, "io"); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+	if err := p.AddTagged(double, "cpu"); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+
+	if got := p.StagesByTag("cpu"); !reflect.DeepEqual(got, []int{1, 3}) {
+		t.Errorf("expected the cpu functions at %v, got %v", []int{1, 3}, got)
+	}
+	if got := p.StagesByTag("io"); !reflect.DeepEqual(got, []int{2}) {
+		t.Errorf("expected the io functions at %v, got %v", []int{2}, got)
+	}
+	if got := p.StagesByTag("gpu"); got != nil {
+		t.Errorf("expected no function for an unknown tag, got %v", got)
+	}
+	if err := p.AddTagged("not a function", "cpu"); err == nil {
+		t.Error("expected an error for a non-function")
+	}
+}
+
+func TestPipe_ExecuteTagged(t *testing.T) {
+	var saved bool
+	p, err := New()
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	if err := p.AddTagged(strings.TrimSpace, "validate"); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+	if err := p.Add(strings.ToUpper); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+	if err := p.AddTagged(func(s string) (string, error) {
+		if s == "" {
+			return "", errors.New("empty")
+		}
+		return s, nil
+	}, "validate"); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+	if err := p.AddTagged(func(s string) string {
+		saved = true
+		return s
+	}, "io"); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+
+	out, err := p.ExecuteTagged("validate", " name ")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{"name"}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{"name"}, out)
+	}
+	if saved {
+		t.Error("expected the untagged and io functions to be skipped")
+	}
+	if _, err := p.ExecuteTagged("validate", "  "); err == nil {
+		t.Error("expected the validation error")
+	}
+	// The output transform only applies if the last function is tagged.
+	p.SetOutputTransform(func(outputs []interface{}) ([]interface{}, error) {
+		return []interface{}{len(outputs)}, nil
+	})
+	if out, err := p.ExecuteTagged("validate", "name"); err != nil || !reflect.DeepEqual(out, []interface{}{"name"}) {
+		t.Errorf("expected the untransformed outputs, got %v, %v", out, err)
+	}
+	if out, err := p.ExecuteTagged("io", "name"); err != nil || !reflect.DeepEqual(out, []interface{}{1}) {
+		t.Errorf("expected the transformed outputs, got %v, %v", out, err)
+	}
+}
+
+func TestPanicError_Stack(t *testing.T) {
+	p, err := New(func(a int) int {
+		var m map[string]int
+		m["a"] = a
+		return a
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	p.SetRecoverPanics(true)
+
+	_, err = p.Execute(1)
+	var pe *PanicError
+	if !errors.As(err, &pe) {
+		t.Fatalf("expected a *PanicError, got %v", err)
+	}
+	if len(pe.Stack) == 0 {
+		t.Fatal("expected a stack trace")
+	}
+	if !bytes.Contains(pe.Stack, []byte("TestPanicError_Stack")) {
+		t.Errorf("expected the stack trace to show the panicking function, got:\n%s", pe.Stack)
+	}
+}
+
+func TestLazy(t *testing.T) {
+	var calls int
+	expensive := Lazy(func() interface{} {
+		calls++
+		return 10
+	})
+
+	p, err := New(
+		func(a int, l Lazy) (int, Lazy, error) {
+			if a < 0 {
+				return 0, nil, Stop("negative")
+			}
+			return a, l, nil
+		},
+		func(a, b int) int { return a * b },
+	)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	if _, err := p.Execute(-1, expensive); !errors.Is(err, ErrStop) {
+		t.Fatalf("expected %v, got %v", ErrStop, err)
+	}
+	if calls != 0 {
+		t.Errorf("expected the thunk not to be called when its function doesn't run, got %d calls", calls)
+	}
+
+	if err := p.Validate(); err != nil {
+		t.Errorf("unexpected validation error: %v", err)
+	}
+	if err := p.Check(4, expensive); err != nil {
+		t.Errorf("unexpected check error: %v", err)
+	}
+
+	out, err := p.Execute(4, expensive)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{40}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{40}, out)
+	}
+	if calls != 1 {
+		t.Errorf("expected the thunk to be called once, got %d calls", calls)
+	}
+}
+
+func TestPipe_Fingerprint(t *testing.T) {
+	p, err := New(strings.TrimSpace, strconv.Atoi)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	fp := p.Fingerprint()
+
+	c := p.Clone()
+	if got := c.Fingerprint(); got != fp {
+		t.Errorf("expected a clone to have the same fingerprint %s, got %s", fp, got)
+	}
+	same, _ := New(strings.ToUpper, func(s string) (int, error) { return len(s), nil })
+	if got := same.Fingerprint(); got != fp {
+		t.Errorf("expected a structurally identical pipe to have the same fingerprint %s, got %s", fp, got)
+	}
+
+	if err := c.Add(double); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+	if c.Fingerprint() == fp {
+		t.Error("expected the fingerprint to change after Add")
+	}
+	if p.Fingerprint() != fp || p.Len() != 2 {
+		t.Error("expected the original pipe not to be affected by its clone")
+	}
+
+	tagged, _ := New(strings.TrimSpace)
+	tagged.AddTagged(strconv.Atoi, "parse")
+	if tagged.Fingerprint() == fp {
+		t.Error("expected tags to change the fingerprint")
+	}
+}
+
+func TestPipe_RequireStages(t *testing.T) {
+	p, err := New()
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	out, err := p.Execute(1, "a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{1, "a"}) {
+		t.Errorf("expected the arguments to pass through, got %v", out)
+	}
+
+	p.RequireStages(true)
+	if _, err := p.Execute(1, "a"); !errors.Is(err, ErrNoStages) {
+		t.Errorf("expected %v, got %v", ErrNoStages, err)
+	}
+
+	if err := p.Add(double); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+	if _, err := p.Execute(1); err != nil {
+		t.Errorf("unexpected error once the pipe has functions: %v", err)
+	}
+}
+
+func TestPipe_SetReturnType(t *testing.T) {
+	p, err := New(strings.TrimSpace, func(s string) int { return len(s) })
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	p.SetReturnType(reflect.TypeOf(int64(0)))
+
+	out, err := p.Execute(" abc ")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{int64(3)}) {
+		t.Errorf("output mismatch: expected %v, got %v (%T)", []interface{}{int64(3)}, out, out[0])
+	}
+
+	p.SetReturnType(reflect.TypeOf(struct{}{}))
+	if _, err := p.Execute("abc"); err == nil {
+		t.Error("expected an error for an inconvertible output")
+	}
+
+	p.SetReturnType(nil)
+	if out, err := p.Execute("abc"); err != nil || !reflect.DeepEqual(out, []interface{}{3}) {
+		t.Errorf("unexpected result without a return type: %v, %v", out, err)
+	}
+
+	// A slice is convertible to an array type, but only of its length.
+	p, _ = New(func() []int { return []int{0} })
+	p.SetReturnType(reflect.TypeOf([4]int{}))
+	if _, err := p.Execute(); err == nil {
+		t.Error("expected an error for a slice shorter than the array")
+	}
+	p.SetReturnType(reflect.TypeOf(&[1]int{}))
+	if out, err := p.Execute(); err != nil || !reflect.DeepEqual(out, []interface{}{&[1]int{0}}) {
+		t.Errorf("expected a pointer to an array of the slice's length, got %v, %v", out, err)
+	}
+}
+
+func TestGetOutput(t *testing.T) {
+	outputs := []interface{}{"abc", 3, nil}
+
+	s, err := GetOutput[string](outputs, 0)
+	if err != nil || s != "abc" {
+		t.Errorf("unexpected result: %q, %v", s, err)
+	}
+	n, err := GetOutput[int](outputs, 1)
+	if err != nil || n != 3 {
+		t.Errorf("unexpected result: %d, %v", n, err)
+	}
+	e, err := GetOutput[error](outputs, 2)
+	if err != nil || e != nil {
+		t.Errorf("unexpected result for a nil output: %v, %v", e, err)
+	}
+
+	if _, err := GetOutput[string](outputs, 3); err == nil || !strings.Contains(err.Error(), "out of range") {
+		t.Errorf("expected an out of range error, got %v", err)
+	}
+	if _, err := GetOutput[string](outputs, -1); err == nil {
+		t.Error("expected an error for a negative index")
+	}
+	if _, err := GetOutput[string](outputs, 1); err == nil || !strings.Contains(err.Error(), "of type int, expected string") {
+		t.Errorf("expected a type mismatch error, got %v", err)
+	}
+	if _, err := GetOutput[int](outputs, 2); err == nil {
+		t.Error("expected an error for a nil output of a non-nillable type")
+	}
+}
+
+func TestPipe_DetectReentrancy(t *testing.T) {
+	var p *Pipe
+	var inner error
+	p, err := New(func(a int) int {
+		if a > 0 {
+			_, inner = p.Execute(a - 1)
+		}
+		return a
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	p.DetectReentrancy(true)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.Execute(1)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("reentrant Execute deadlocked")
+	}
+	if !errors.Is(inner, ErrReentrant) {
+		t.Errorf("expected %v, got %v", ErrReentrant, inner)
+	}
+
+	// The pipe can still be executed afterwards, including from other goroutines.
+	results := p.ExecuteEachConcurrent([]interface{}{0, 0}, 2)
+	for i, r := range results {
+		if r.Err != nil {
+			t.Errorf("item %d: unexpected error: %v", i, r.Err)
+		}
+	}
+}
+
+func TestPipe_AddParallel(t *testing.T) {
+	sum := func(n int) int {
+		var total int
+		for i := 1; i <= n; i++ {
+			total += i
+		}
+		return total
+	}
+	product := func(n int) (int, error) {
+		if n < 0 {
+			return 0, errors.New("negative")
+		}
+		total := 1
+		for i := 2; i <= n; i++ {
+			total = total * i % 1000003
+		}
+		return total, nil
+	}
+	p, err := New(strconv.Atoi)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	if err := p.AddParallel(sum, product); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+	if err := p.Add(func(s, p int) string { return fmt.Sprintf("%d/%d", s, p) }); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+
+	out, err := p.Execute("100000")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	prod, _ := product(100000)
+	expected := fmt.Sprintf("%d/%d", sum(100000), prod)
+	if !reflect.DeepEqual(out, []interface{}{expected}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{expected}, out)
+	}
+
+	var stageErr *StageError
+	if _, err := p.Execute("-1"); !errors.As(err, &stageErr) || stageErr.Index != 1 {
+		t.Errorf("expected a *StageError of function 1, got %v", err)
+	}
+
+	p, _ = New()
+	p.SetRecoverPanics(true)
+	if err := p.AddParallel(sum, func(int) int { panic("boom") }); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+	var pe *PanicError
+	if _, err := p.Execute(1); !errors.As(err, &pe) {
+		t.Errorf("expected a *PanicError, got %v", err)
+	}
+	if err := p.AddParallel(); err == nil {
+		t.Error("expected an error without functions")
+	}
+}
+
+func TestPipe_AddMultiplex(t *testing.T) {
+	p, err := New(strings.TrimSpace)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	if err := p.AddMultiplex(strings.ToUpper, func(s string) int { return len(s) }); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+	if err := p.Add(func(s string, n int) string { return fmt.Sprintf("%s/%d", s, n) }); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+
+	out, err := p.Execute(" abc ")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{"ABC/3"}) {
+		t.Errorf("output mismatch: expected %v, got %v", []interface{}{"ABC/3"}, out)
+	}
+
+	p, _ = New()
+	if err := p.AddMultiplex(strconv.Atoi, strings.ToUpper); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+	if _, err := p.Execute("x"); err == nil {
+		t.Error("expected the error of a multiplexed function")
+	}
+	if err := p.AddMultiplex(); err == nil {
+		t.Error("expected an error without functions")
+	}
+}
@@ -0,0 +1,64 @@
+package pipe
+
+import (
+	"errors"
+	"sync"
+)
+
+// WeightedVariant is a variant of a WeightedPool, selected in proportion to its weight.
+type WeightedVariant = struct {
+	Pipe   *Pipe
+	Weight int
+}
+
+// WeightedPool executes each call through one of several pipes, e.g. variants of a pipe being
+// rolled out gradually, selected by weighted round-robin: out of a run of calls as long as the
+// sum of the weights, each variant executes as many as its weight, interleaved with the others.
+type WeightedPool struct {
+	mux      sync.Mutex
+	variants []WeightedVariant
+	current  []int // current weights of the smooth weighted round-robin
+	total    int
+}
+
+// NewWeightedPool instantiates a new WeightedPool of the given variants. Variants without a
+// positive weight are never selected.
+func NewWeightedPool(variants []WeightedVariant) *WeightedPool {
+	wp := &WeightedPool{}
+	for _, v := range variants {
+		if v.Pipe != nil && v.Weight > 0 {
+			wp.variants = append(wp.variants, v)
+			wp.total += v.Weight
+		}
+	}
+	wp.current = make([]int, len(wp.variants))
+	return wp
+}
+
+// Execute selects the next variant and executes its pipe with args, see Pipe.Execute. It fails
+// if the pool has no variant with a positive weight.
+func (wp *WeightedPool) Execute(args ...interface{}) ([]interface{}, error) {
+	p := wp.next()
+	if p == nil {
+		return nil, errors.New("pool has no variant with a positive weight")
+	}
+	return p.Execute(args...)
+}
+
+// next returns the pipe of the next variant, or nil if there is none.
+func (wp *WeightedPool) next() *Pipe {
+	wp.mux.Lock()
+	defer wp.mux.Unlock()
+	selected := -1
+	for i, v := range wp.variants {
+		wp.current[i] += v.Weight
+		if selected < 0 || wp.current[i] > wp.current[selected] {
+			selected = i
+		}
+	}
+	if selected < 0 {
+		return nil
+	}
+	wp.current[selected] -= wp.total
+	return wp.variants[selected].Pipe
+}
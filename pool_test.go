@@ -0,0 +1,36 @@
+package pipe
+
+import (
+	"testing"
+)
+
+func TestWeightedPool_Execute(t *testing.T) {
+	variant := func(name string) *Pipe {
+		p, err := New(func() string { return name })
+		if err != nil {
+			t.Fatalf("unexpected error creating a new pipe: %v", err)
+		}
+		return p
+	}
+	wp := NewWeightedPool([]WeightedVariant{
+		{Pipe: variant("stable"), Weight: 9},
+		{Pipe: variant("canary"), Weight: 1},
+		{Pipe: variant("disabled"), Weight: 0},
+	})
+
+	counts := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		out, err := wp.Execute()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		counts[out[0].(string)]++
+	}
+	if counts["stable"] != 900 || counts["canary"] != 100 || counts["disabled"] != 0 {
+		t.Errorf("expected the selection to follow the weights, got %v", counts)
+	}
+
+	if _, err := NewWeightedPool(nil).Execute(); err == nil {
+		t.Error("expected an error for an empty pool")
+	}
+}
@@ -0,0 +1,75 @@
+package pipe
+
+import (
+	"sync"
+)
+
+// QueueResult is the result of a job submitted to a Queue.
+type QueueResult = struct {
+	ID      string
+	Outputs []interface{}
+	Err     error
+}
+
+// Queue executes jobs submitted asynchronously through a Pipe, on a pool of workers.
+type Queue struct {
+	pipe    *Pipe
+	jobs    chan queueJob
+	results chan QueueResult
+	wg      sync.WaitGroup
+}
+
+// queueJob is a job submitted to a Queue.
+type queueJob struct {
+	id   string
+	args []interface{}
+}
+
+// NewQueue instantiates a new Queue executing jobs through p with the given number of workers.
+func NewQueue(p *Pipe, workers int) *Queue {
+	if workers <= 0 {
+		workers = 1
+	}
+	q := &Queue{
+		pipe:    p,
+		jobs:    make(chan queueJob, workers),
+		results: make(chan QueueResult, workers),
+	}
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+	go func() {
+		q.wg.Wait()
+		close(q.results)
+	}()
+	return q
+}
+
+// work executes jobs until the queue is closed.
+func (q *Queue) work() {
+	defer q.wg.Done()
+	for job := range q.jobs {
+		outputs, err := q.pipe.Execute(job.args...)
+		q.results <- QueueResult{ID: job.id, Outputs: outputs, Err: err}
+	}
+}
+
+// Submit adds a job executing the pipe with args, whose result is identified by id. It blocks
+// while all workers are busy and their backlog is full, so results must be consumed concurrently.
+//
+// Submit must not be called after Close.
+func (q *Queue) Submit(id string, args ...interface{}) {
+	q.jobs <- queueJob{id: id, args: args}
+}
+
+// Results returns the channel on which the results of the jobs are sent, in the order they
+// complete. It is closed once the queue is closed and all submitted jobs are done.
+func (q *Queue) Results() <-chan QueueResult {
+	return q.results
+}
+
+// Close stops accepting jobs. Jobs that were already submitted still run.
+func (q *Queue) Close() {
+	close(q.jobs)
+}
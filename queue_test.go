@@ -0,0 +1,45 @@
+package pipe
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestQueue(t *testing.T) {
+	p, err := New(func(a int) int { return a * a })
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	q := NewQueue(p, 3)
+
+	go func() {
+		for i := 0; i < 5; i++ {
+			q.Submit(fmt.Sprintf("job-%d", i), i)
+		}
+		q.Submit("invalid", "x")
+		q.Close()
+	}()
+
+	results := make(map[string]QueueResult)
+	for r := range q.Results() {
+		results[r.ID] = r
+	}
+
+	if len(results) != 6 {
+		t.Fatalf("expected 6 results, got %d", len(results))
+	}
+	for i := 0; i < 5; i++ {
+		r := results[fmt.Sprintf("job-%d", i)]
+		if r.Err != nil {
+			t.Errorf("job %d: unexpected error: %v", i, r.Err)
+			continue
+		}
+		if !reflect.DeepEqual(r.Outputs, []interface{}{i * i}) {
+			t.Errorf("job %d: output mismatch: expected %v, got %v", i, []interface{}{i * i}, r.Outputs)
+		}
+	}
+	if results["invalid"].Err == nil {
+		t.Error("expected an error for the invalid job")
+	}
+}
@@ -0,0 +1,295 @@
+package pipe
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Stream executes the pipe once for every slice of arguments received from in and sends each
+// run's result on the returned channel, in order. The returned channel is closed once in is
+// closed and every result has been sent.
+//
+// Stream is equivalent to OpenStream(in).Results(), without flow control.
+func (p *Pipe) Stream(in <-chan []interface{}) <-chan Result {
+	return p.OpenStream(in).Results()
+}
+
+// StreamContext behaves like Stream, but stops when ctx is done: no more arguments are received
+// from in, the executions in progress, which run with ctx (see ExecuteContext), complete and
+// their results are sent, and then the returned channel is closed. Cancelling ctx therefore
+// shuts the stream down gracefully, even if in is never closed.
+func (p *Pipe) StreamContext(ctx context.Context, in <-chan []interface{}) <-chan Result {
+	return p.openStream(ctx, in).Results()
+}
+
+// StreamHandle is a stream of executions of a pipe, returned by OpenStream, that can be paused.
+type StreamHandle struct {
+	results chan Result
+	gate    pauseGate
+}
+
+// OpenStream behaves like Stream, but returns a handle to the stream, whose results are
+// received from Results, and which can be paused and resumed.
+//
+// If the pipe contains stages inserted by AddWindow when the stream is opened, the functions
+// after each of them run once per window rather than once per item, see AddWindow.
+func (p *Pipe) OpenStream(in <-chan []interface{}) *StreamHandle {
+	return p.openStream(context.Background(), in)
+}
+
+// openStream opens a stream that stops when ctx is done, see StreamContext.
+func (p *Pipe) openStream(ctx context.Context, in <-chan []interface{}) *StreamHandle {
+	h := &StreamHandle{results: make(chan Result)}
+
+	p.mux.Lock()
+	var windows []int // indexes of the window stages
+	for i, s := range p.funcs {
+		if _, ok := s.fn.(*windowStage); ok {
+			windows = append(windows, i)
+		}
+	}
+	durations := make([]time.Duration, len(windows))
+	for k, i := range windows {
+		durations[k] = p.funcs[i].fn.(*windowStage).d
+	}
+	p.mux.Unlock()
+
+	// Without windows, the whole pipe runs once per item. Otherwise, the functions before the
+	// first window run once per item, and the ones after each window once per window.
+	first := h.results
+	if len(windows) > 0 {
+		first = make(chan Result)
+		results := (<-chan Result)(first)
+		for k, i := range windows {
+			results = window(durations[k], results)
+			to := math.MaxInt
+			if k+1 < len(windows) {
+				to = windows[k+1]
+			}
+			results = p.streamSegment(ctx, i+1, to, k+1 == len(windows), results)
+		}
+		go func() {
+			for r := range results {
+				h.results <- r
+			}
+			close(h.results)
+		}()
+	}
+
+	go func() {
+		defer close(first)
+		for {
+			h.gate.wait(ctx.Done())
+			if ctx.Err() != nil {
+				return
+			}
+			var args []interface{}
+			var ok bool
+			select {
+			case args, ok = <-in:
+			case <-ctx.Done():
+			}
+			if !ok {
+				return
+			}
+			// The stream may have been paused while waiting for args: hold them until resumed.
+			h.gate.wait(ctx.Done())
+			if len(windows) == 0 {
+				outputs, err := p.ExecuteContext(ctx, args...)
+				first <- Result{Outputs: outputs, Err: err}
+				continue
+			}
+			first <- p.executeSegment(ctx, 0, windows[0], false, args)
+		}
+	}()
+	return h
+}
+
+// executeSegment runs the functions of the pipe at the indexes in [from, to) with args and ctx.
+// The output transform only applies to the final segment.
+func (p *Pipe) executeSegment(ctx context.Context, from, to int, final bool, args []interface{}) Result {
+	if err := p.lock(); err != nil {
+		return Result{Err: err}
+	}
+	defer p.mux.Unlock()
+	if from == 0 {
+		args = p.withDefaults(args)
+	}
+	outputs, err := p.execute(ctx, args, execOptions{
+		only:    func(index int) bool { return index >= from && index < to },
+		partial: !final,
+	})
+	return Result{Outputs: outputs, Err: err}
+}
+
+// streamSegment runs the functions of the pipe at the indexes in [from, to) with the outputs
+// of every successful result received from in, and sends their results on the returned
+// channel. Failed results are forwarded as is.
+func (p *Pipe) streamSegment(ctx context.Context, from, to int, final bool, in <-chan Result) <-chan Result {
+	out := make(chan Result)
+	go func() {
+		defer close(out)
+		for r := range in {
+			if r.Err == nil {
+				r = p.executeSegment(ctx, from, to, final, r.Outputs)
+			}
+			out <- r
+		}
+	}()
+	return out
+}
+
+// Results returns the channel on which the result of every run is sent, in order. It is
+// closed once the input channel is closed and every result has been sent.
+func (h *StreamHandle) Results() <-chan Result {
+	return h.results
+}
+
+// PauseStream pauses the stream: no arguments are executed, and no more are received from the
+// input channel, until ResumeStream is called, which applies backpressure to the senders.
+// Arguments received before the call but not executed yet are held until then, while a run
+// that already started completes and its result is sent. Other streams of the same pipe aren't
+// affected.
+//
+// PauseStream and ResumeStream are safe to call concurrently, from any goroutine. Pausing a
+// paused stream, or resuming a running one, has no effect.
+func (h *StreamHandle) PauseStream() {
+	h.gate.pause()
+}
+
+// ResumeStream resumes the stream paused by PauseStream.
+func (h *StreamHandle) ResumeStream() {
+	h.gate.resume()
+}
+
+// pauseGate blocks the callers of wait while it is paused. Its zero value is not paused.
+type pauseGate struct {
+	mux     sync.Mutex
+	resumed chan struct{} // closed on resume, nil when not paused
+}
+
+func (g *pauseGate) pause() {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	if g.resumed == nil {
+		g.resumed = make(chan struct{})
+	}
+}
+
+func (g *pauseGate) resume() {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	if g.resumed != nil {
+		close(g.resumed)
+		g.resumed = nil
+	}
+}
+
+// wait waits until the gate is resumed, or done is closed.
+func (g *pauseGate) wait(done <-chan struct{}) {
+	g.mux.Lock()
+	resumed := g.resumed
+	g.mux.Unlock()
+	if resumed != nil {
+		select {
+		case <-resumed:
+		case <-done:
+		}
+	}
+}
+
+// AddWindow inserts a stage to the end of the execution stack that, during a stream (see
+// Stream and OpenStream), collects the items reaching it during a window of duration d,
+// starting with the first item, and then passes them to the next function as a single
+// []interface{}. The functions after the window therefore run once per window. Once the
+// input of the stream is closed, the items of the last window are passed without waiting for
+// the end of the window. Failed items are not collected, their results are sent immediately.
+//
+// An item is the single value returned by the function before the window, or a []interface{}
+// of its values if it returns several. Outside a stream, every execution is a window of one item.
+func (p *Pipe) AddWindow(d time.Duration) {
+	p.AddStage(&windowStage{d: d})
+}
+
+// windowStage is the Stage inserted by AddWindow, which Stream replaces by a window.
+type windowStage struct {
+	d time.Duration
+}
+
+func (s *windowStage) Run(inputs []interface{}) ([]interface{}, error) {
+	return []interface{}{[]interface{}{windowItem(inputs)}}, nil
+}
+
+// windowItem returns the item of a window made of the given values.
+func windowItem(values []interface{}) interface{} {
+	if len(values) == 1 {
+		return values[0]
+	}
+	return values
+}
+
+// window collects the outputs of the successful results received from in during windows of
+// duration d and sends them as the only output of a result on the returned channel. Failed
+// results are forwarded immediately.
+func window(d time.Duration, in <-chan Result) <-chan Result {
+	out := make(chan Result)
+	go func() {
+		defer close(out)
+		var items []interface{}
+		var end <-chan time.Time
+		for {
+			select {
+			case r, ok := <-in:
+				if !ok {
+					if items != nil {
+						out <- Result{Outputs: []interface{}{items}}
+					}
+					return
+				}
+				if r.Err != nil {
+					out <- r
+					continue
+				}
+				if items == nil {
+					end = time.After(d)
+				}
+				items = append(items, windowItem(r.Outputs))
+			case <-end:
+				out <- Result{Outputs: []interface{}{items}}
+				items, end = nil, nil
+			}
+		}
+	}()
+	return out
+}
+
+// AddThrottle inserts a stage to the end of the execution stack that forwards its inputs
+// unchanged, but delays them so that at least minInterval elapses between two items going
+// through it. Items are never dropped.
+//
+// It is mostly useful with Stream, to rate-limit the items flowing to the next functions.
+func (p *Pipe) AddThrottle(minInterval time.Duration) {
+	p.AddStage(&throttleStage{minInterval: minInterval})
+}
+
+// throttleStage is the Stage inserted by AddThrottle.
+type throttleStage struct {
+	minInterval time.Duration
+
+	mux  sync.Mutex
+	last time.Time
+}
+
+func (s *throttleStage) Run(inputs []interface{}) ([]interface{}, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if !s.last.IsZero() {
+		if wait := s.minInterval - time.Since(s.last); wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+	s.last = time.Now()
+	return inputs, nil
+}
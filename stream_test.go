@@ -0,0 +1,187 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPipe_AddThrottle(t *testing.T) {
+	const interval = 20 * time.Millisecond
+
+	p, err := New(func(a int) int { return a * 2 })
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	p.AddThrottle(interval)
+	var times []time.Time
+	if err := p.Add(func(a int) int {
+		times = append(times, time.Now())
+		return a
+	}); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+
+	in := make(chan []interface{})
+	go func() {
+		defer close(in)
+		for i := 0; i < 3; i++ {
+			in <- []interface{}{i}
+		}
+	}()
+
+	var outputs []interface{}
+	for r := range p.Stream(in) {
+		if r.Err != nil {
+			t.Fatalf("unexpected error: %v", r.Err)
+		}
+		outputs = append(outputs, r.Outputs...)
+	}
+
+	if !reflect.DeepEqual(outputs, []interface{}{0, 2, 4}) {
+		t.Errorf("outputs mismatch: expected %v, got %v", []interface{}{0, 2, 4}, outputs)
+	}
+	for i := 1; i < len(times); i++ {
+		if d := times[i].Sub(times[i-1]); d < interval {
+			t.Errorf("item %d forwarded %v after the previous one, expected at least %v", i, d, interval)
+		}
+	}
+}
+
+func TestStreamHandle_PauseStream(t *testing.T) {
+	p, err := New(func(a int) int { return a + 1 })
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	in := make(chan []interface{})
+	h := p.OpenStream(in)
+	h.PauseStream()
+	go func() {
+		in <- []interface{}{1}
+	}()
+	select {
+	case r := <-h.Results():
+		t.Fatalf("expected no item to flow while paused, got %v", r)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	h.ResumeStream()
+	if r := <-h.Results(); !reflect.DeepEqual(r.Outputs, []interface{}{2}) {
+		t.Fatalf("output mismatch: expected %v, got %v", []interface{}{2}, r.Outputs)
+	}
+
+	// Pausing another stream of the same pipe doesn't affect h.
+	other := p.OpenStream(make(chan []interface{}))
+	other.PauseStream()
+	go func() {
+		for i := 2; i <= 3; i++ {
+			in <- []interface{}{i}
+		}
+		close(in)
+	}()
+	var outputs []interface{}
+	for r := range h.Results() {
+		outputs = append(outputs, r.Outputs...)
+	}
+	if !reflect.DeepEqual(outputs, []interface{}{3, 4}) {
+		t.Errorf("outputs mismatch: expected %v, got %v", []interface{}{3, 4}, outputs)
+	}
+}
+
+func TestPipe_StreamContext(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	p, err := New(func(a int) int {
+		if a == 2 {
+			close(started)
+			<-release
+		}
+		return a * 10
+	}, func(a int) int { return a + 1 })
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan []interface{}) // Never closed.
+	results := p.StreamContext(ctx, in)
+	in <- []interface{}{1}
+	if r := <-results; !reflect.DeepEqual(r.Outputs, []interface{}{11}) {
+		t.Fatalf("output mismatch: expected %v, got %v", []interface{}{11}, r.Outputs)
+	}
+
+	// The run in progress when the context is cancelled completes, and stops before its next
+	// function.
+	in <- []interface{}{2}
+	<-started
+	cancel()
+	close(release)
+	select {
+	case r := <-results:
+		if !errors.Is(r.Err, context.Canceled) {
+			t.Errorf("expected the run in progress to fail with %v, got %v", context.Canceled, r.Err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the result of the run in progress")
+	}
+	select {
+	case r, ok := <-results:
+		if ok {
+			t.Errorf("expected the stream to be closed, got %v", r)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the stream to be closed promptly")
+	}
+}
+
+func TestPipe_AddWindow(t *testing.T) {
+	const window = 100 * time.Millisecond
+
+	p, err := New(func(a int) int { return a * 2 })
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	p.AddWindow(window)
+	if err := p.Add(func(items []interface{}) int {
+		var sum int
+		for _, item := range items {
+			sum += item.(int)
+		}
+		return sum
+	}); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+
+	in := make(chan []interface{})
+	go func() {
+		defer close(in)
+		in <- []interface{}{1}
+		in <- []interface{}{2}
+		time.Sleep(2 * window)
+		// The last window is cut short by closing the input.
+		in <- []interface{}{3}
+		in <- []interface{}{4}
+	}()
+
+	var outputs []interface{}
+	for r := range p.Stream(in) {
+		if r.Err != nil {
+			t.Fatalf("unexpected error: %v", r.Err)
+		}
+		outputs = append(outputs, r.Outputs...)
+	}
+	if !reflect.DeepEqual(outputs, []interface{}{6, 14}) {
+		t.Errorf("outputs mismatch: expected %v, got %v", []interface{}{6, 14}, outputs)
+	}
+
+	out, err := p.Execute(5)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{10}) {
+		t.Errorf("output mismatch outside a stream: expected %v, got %v", []interface{}{10}, out)
+	}
+}
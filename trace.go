@@ -0,0 +1,36 @@
+package pipe
+
+import "context"
+
+// Tracer starts the spans traced around the functions added with AddTraced, e.g. an adapter of
+// an OpenTelemetry tracer.
+type Tracer interface {
+	// StartSpan starts a span with the given name, as a child of the span of ctx if any. It
+	// returns a context holding the new span, and a function ending it.
+	StartSpan(ctx context.Context, name string) (context.Context, func())
+}
+
+// SetTracer sets the tracer starting the spans of the functions added with AddTraced. A nil
+// tracer disables tracing.
+func (p *Pipe) SetTracer(t Tracer) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.tracer = t
+}
+
+// AddTraced inserts a function (or Stage) to the end of the execution stack that runs in a span
+// named spanName, started by the tracer (see SetTracer) right before it runs and ended right
+// after, whether it fails or not. The span is a child of the one of the context passed to
+// ExecuteContext, if any.
+//
+// The context of the span is the one the function's parameters bound with BindContextValue are
+// filled from, so that the function can get the span, e.g. to add attributes to it.
+func (p *Pipe) AddTraced(f interface{}, spanName string) error {
+	if err := checkFunc(f); err != nil {
+		return err
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.insert(stage{fn: f, spanName: spanName})
+	return nil
+}
@@ -0,0 +1,71 @@
+package pipe
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type spanKey struct{}
+
+type fakeSpan struct {
+	name   string
+	parent *fakeSpan
+	ended  bool
+}
+
+type fakeTracer struct {
+	spans []*fakeSpan
+}
+
+func (t *fakeTracer) StartSpan(ctx context.Context, name string) (context.Context, func()) {
+	parent, _ := ctx.Value(spanKey{}).(*fakeSpan)
+	span := &fakeSpan{name: name, parent: parent}
+	t.spans = append(t.spans, span)
+	return context.WithValue(ctx, spanKey{}, span), func() { span.ended = true }
+}
+
+func TestPipe_AddTraced(t *testing.T) {
+	p, err := New(strings.TrimSpace)
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	p.BindContextValue(reflect.TypeOf(&fakeSpan{}), spanKey{})
+	var inSpan *fakeSpan
+	if err := p.AddTraced(func(span *fakeSpan, s string) (int, error) {
+		inSpan = span
+		return strconv.Atoi(s)
+	}, "parse"); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+
+	// Without a tracer, traced functions run like any other.
+	if out, err := p.Execute(" 1 "); err != nil || !reflect.DeepEqual(out, []interface{}{1}) {
+		t.Fatalf("expected [1], got %v, %v", out, err)
+	}
+
+	tracer := &fakeTracer{}
+	p.SetTracer(tracer)
+	root := &fakeSpan{name: "request"}
+	ctx := context.WithValue(context.Background(), spanKey{}, root)
+	if _, err := p.ExecuteContext(ctx, " 2 "); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := p.ExecuteContext(ctx, "x"); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if len(tracer.spans) != 2 {
+		t.Fatalf("expected a span per run of the traced function, got %d", len(tracer.spans))
+	}
+	for _, span := range tracer.spans {
+		if span.name != "parse" || span.parent != root || !span.ended {
+			t.Errorf("expected an ended span parse, child of the request span, got %+v", span)
+		}
+	}
+	if inSpan != tracer.spans[1] {
+		t.Errorf("expected the function to get its span, got %+v", inSpan)
+	}
+}
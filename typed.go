@@ -0,0 +1,75 @@
+package pipe
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// TypedPipe is a pipe taking a single In argument and returning a single Out output, e.g. built
+// with AddTyped, whose executions are typed at compile time with Run.
+type TypedPipe[In, Out any] struct {
+	*Pipe
+}
+
+// NewTypedPipe returns p as a TypedPipe, after checking, like Validate, that its functions fit
+// together, that an In can be passed to the first one, and that the last one returns a single
+// value, besides an error, assignable to an Out, or that the return type is one (see
+// SetReturnType). An empty pipe returns its argument, so an In must be assignable to an Out.
+//
+// The ends of the pipe whose signature is only known at runtime, a Stage, a function added with
+// AddSpread or an output transform, are only checked by Run.
+func NewTypedPipe[In, Out any](p *Pipe) (*TypedPipe[In, Out], error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	inType := reflect.TypeOf((*In)(nil)).Elem()
+	outType := reflect.TypeOf((*Out)(nil)).Elem()
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if len(p.funcs) == 0 {
+		if !inType.AssignableTo(outType) {
+			return nil, fmt.Errorf("empty pipe returns its %v argument, not a %v", inType, outType)
+		}
+		return &TypedPipe[In, Out]{p}, nil
+	}
+
+	first, last := p.funcs[0], p.funcs[len(p.funcs)-1]
+	if fnType := funcType(first.fn); fnType != nil {
+		if params := p.paramTypes(fnType); len(params) != 1 {
+			return nil, fmt.Errorf("function 0 takes %d arguments (%s), expected a single %v", len(params), typeList(params), inType)
+		}
+		if err := p.checkArgs(0, fnType, []reflect.Type{inType}); err != nil {
+			return nil, err
+		}
+	}
+
+	var outs []reflect.Type
+	switch fnType := funcType(last.fn); {
+	case p.returnType != nil:
+		outs = []reflect.Type{p.returnType}
+	case p.outputTransform != nil || fnType == nil || last.spread:
+		return &TypedPipe[In, Out]{p}, nil
+	default:
+		outs = p.forwardedTypes(fnType)
+	}
+	if len(outs) != 1 || !outs[0].AssignableTo(outType) {
+		return nil, fmt.Errorf("pipe returns outputs (%s), expected a single %v", typeList(outs), outType)
+	}
+	return &TypedPipe[In, Out]{p}, nil
+}
+
+// Run executes the pipe with in, see Pipe.Execute, and returns its single output as an Out. It
+// fails if the pipe fails, or doesn't return a single output of that type.
+func (tp *TypedPipe[In, Out]) Run(in In) (Out, error) {
+	outputs, err := tp.Execute(in)
+	if err != nil {
+		var zero Out
+		return zero, err
+	}
+	if len(outputs) != 1 {
+		var zero Out
+		return zero, fmt.Errorf("got %d outputs, expected a single %v", len(outputs), reflect.TypeOf((*Out)(nil)).Elem())
+	}
+	return GetOutput[Out](outputs, 0)
+}
@@ -0,0 +1,54 @@
+package pipe
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestTypedPipe_Run(t *testing.T) {
+	p, err := New()
+	if err != nil {
+		t.Fatalf("unexpected error creating a new pipe: %v", err)
+	}
+	if err := AddTyped(p, func(n int) int { return n * 2 }); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+	if err := AddTyped(p, strconv.Itoa); err != nil {
+		t.Fatalf("unexpected error adding functions to pipe: %v", err)
+	}
+
+	tp, err := NewTypedPipe[int, string](p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out, err := tp.Run(21)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "42" {
+		t.Errorf("output mismatch: expected %q, got %q", "42", out)
+	}
+
+	if _, err := NewTypedPipe[string, string](p); err == nil {
+		t.Error("expected an error for a mismatched input type")
+	}
+	if _, err := NewTypedPipe[int, int](p); err == nil || !strings.Contains(err.Error(), "expected a single int") {
+		t.Errorf("expected an error for a mismatched output type, got %v", err)
+	}
+	if _, err := NewTypedPipe[int, int](Concat()); err != nil {
+		t.Errorf("unexpected error for an empty pipe: %v", err)
+	}
+
+	// Outputs only known at runtime are checked by Run.
+	p.SetOutputTransform(func(outputs []interface{}) ([]interface{}, error) {
+		return append(outputs, "extra"), nil
+	})
+	tp, err = NewTypedPipe[int, string](p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := tp.Run(1); err == nil || !strings.Contains(err.Error(), "got 2 outputs") {
+		t.Errorf("expected an error for extra outputs, got %v", err)
+	}
+}